@@ -0,0 +1,178 @@
+// Package backend defines the sector builder interface shared by the filutil
+// sector builder backends, so that the sector workflow can be driven without
+// knowing which backend does the work.
+//
+// Backends register themselves by name, like database/sql drivers, so a
+// backend is available once its package is imported: the sector builders of
+// go-filecoin are github.com/filcloud/filutil/backend/sectorbuilder and
+// github.com/filcloud/filutil/backend/simple, and the pure Go mock is
+// github.com/filcloud/filutil/backend/mock.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// SectorBuilder stages pieces into sectors, seals them and proves them.
+type SectorBuilder interface {
+	// AddPiece adds the piece of pieceSize bytes read from r into a staged
+	// sector, and returns the sector ID.
+	AddPiece(ctx context.Context, pieceRef string, pieceSize uint64, r io.Reader) (sectorID uint64, err error)
+	// Seal seals the staged sectors, or all unsealed staged sectors if
+	// sectorIDs is empty.
+	Seal(ctx context.Context, sectorIDs []uint64) error
+	// ListStaged lists the staged sectors which are not sealed yet.
+	ListStaged() ([]StagedSector, error)
+	// ListSealed lists the sealed sectors.
+	ListSealed() ([]SealedSector, error)
+	// GeneratePoSt generates the PoSt proof of the sealed sectors under the
+	// challenge seed, declaring faults.
+	GeneratePoSt(challengeSeed [32]byte, sectorIDs []uint64, faults []uint64) ([]byte, error)
+	Close() error
+}
+
+// FileAdder is implemented by the sector builders which read pieces from
+// files, so that a piece which is the whole file at path is added without
+// copying it first.
+type FileAdder interface {
+	AddPieceFile(ctx context.Context, pieceRef string, pieceSize uint64, path string) (sectorID uint64, err error)
+}
+
+// Verifier verifies the proofs generated by the sector builders of a backend.
+type Verifier interface {
+	VerifySeal(sectorSize uint64, sector SealedSector) (bool, error)
+	VerifyPoSt(sectorSize uint64, challengeSeed [32]byte, sectors []SealedSector, faults []uint64, proof []byte) (bool, error)
+}
+
+type PieceInfo struct {
+	Ref  string
+	Size uint64
+}
+
+type StagedSector struct {
+	SectorID uint64
+	// PiecesKnown tells whether Pieces is available, since not every backend
+	// exposes the pieces of staged sectors.
+	PiecesKnown bool
+	Pieces      []PieceInfo
+}
+
+type SealedSector struct {
+	SectorID  uint64
+	ProverID  [31]byte
+	CommD     [32]byte
+	CommR     [32]byte
+	CommRStar [32]byte
+	Proof     []byte
+	Pieces    []PieceInfo
+}
+
+// Config configures opening a sector builder. Zero values mean the backend
+// defaults.
+type Config struct {
+	// Dir is the filutil directory holding the sector builder data.
+	Dir        string
+	SectorSize uint64
+	// MaxParallel is the max number of sectors sealed at the same time, if
+	// the backend can seal sectors in parallel.
+	MaxParallel int
+	// MinerAddr is the address of the miner the sectors are sealed for, if
+	// the backend seals sectors of a miner.
+	MinerAddr string
+	// Progress receives the progress of adding pieces, which is dropped if
+	// nil.
+	Progress ProgressFunc
+}
+
+const (
+	SealOrderOldest  = "oldest"
+	SealOrderFullest = "fullest"
+)
+
+// SealOptions controls how staged sectors are scheduled for sealing, for the
+// sector builders which seal by more than the selected sectors.
+type SealOptions struct {
+	// MaxParallel is the max number of sectors sealed at the same time.
+	MaxParallel int
+	// Order is the order to seal sectors in, oldest or fullest first.
+	Order string
+	// MemoryPerSector is the available memory required to start sealing one
+	// more sector, zero for the default estimate.
+	MemoryPerSector uint64
+	// Sectors selects the staged sectors to seal, empty for all.
+	Sectors []uint64
+	// MinFill skips staged sectors filled less than this percent.
+	MinFill float64
+	// Resume adds the sectors left in sealing state by a dead process.
+	Resume bool
+	// RetryFailed adds the sectors which failed sealing.
+	RetryFailed bool
+}
+
+// Backend is a named sector builder implementation.
+type Backend struct {
+	Name string
+	// Open opens the sector builder. Only one sector builder of a directory
+	// may be open at a time.
+	Open func(cfg Config) (SectorBuilder, error)
+	// MaxBytesPerSector returns the max piece bytes a sector of the sector
+	// size holds.
+	MaxBytesPerSector func(sectorSize uint64) uint64
+	Verifier          Verifier
+}
+
+var (
+	lk       sync.Mutex
+	backends = map[string]*Backend{}
+)
+
+// Register makes the backend available by its name. It panics if a backend is
+// registered twice.
+func Register(b *Backend) {
+	lk.Lock()
+	defer lk.Unlock()
+	if _, ok := backends[b.Name]; ok {
+		panic(fmt.Sprintf("backend %s registered twice", b.Name))
+	}
+	backends[b.Name] = b
+}
+
+// Get returns the backend registered by name.
+func Get(name string) (*Backend, error) {
+	lk.Lock()
+	defer lk.Unlock()
+	b, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, expect one of %v", name, namesLocked())
+	}
+	return b, nil
+}
+
+// Names returns the sorted names of the registered backends.
+func Names() []string {
+	lk.Lock()
+	defer lk.Unlock()
+	return namesLocked()
+}
+
+func namesLocked() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens a sector builder of the backend registered by name.
+func Open(name string, cfg Config) (SectorBuilder, error) {
+	b, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return b.Open(cfg)
+}
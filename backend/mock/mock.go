@@ -0,0 +1,416 @@
+// Package mock is a pure Go sector builder backend, which stages and packs
+// pieces like a real sector builder but fakes sealing and proving with
+// deterministic hashes. It needs neither the proofs library nor parameters,
+// so the sector workflow can be exercised end to end in seconds.
+//
+// Sealing copies the staged sector data, padded to the sector, into a replica
+// file. Commitments are derived from the replica data, so that damaging a
+// replica makes its PoSt fail verification, as with real proofs.
+package mock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+const Name = "mock"
+
+// DefaultSectorSize is the sector size if the config does not set one.
+const DefaultSectorSize = 1024
+
+const stateFileName = "state.json"
+
+func init() {
+	backend.Register(&backend.Backend{
+		Name:              Name,
+		Open:              Open,
+		MaxBytesPerSector: MaxBytesPerSector,
+		Verifier:          Verifier{},
+	})
+}
+
+// MaxBytesPerSector returns the unpadded piece bytes of a sector, as Fr32
+// padding takes 1 of every 128 bytes.
+func MaxBytesPerSector(sectorSize uint64) uint64 {
+	return sectorSize / 128 * 127
+}
+
+// proverID is the fixed prover ID of mock sectors.
+var proverID = func() (id [31]byte) {
+	h := sha256.Sum256([]byte("filutil mock prover"))
+	copy(id[:], h[:])
+	return id
+}()
+
+type stagedSector struct {
+	SectorID uint64
+	Pieces   []backend.PieceInfo
+	// Used is the piece bytes written into the sector.
+	Used uint64
+}
+
+type state struct {
+	SectorSize   uint64
+	LastSectorID uint64
+	Staged       []*stagedSector
+	Sealed       []backend.SealedSector
+}
+
+// SectorBuilder keeps its state in a JSON file and sector data in files under
+// the mock directory of the filutil directory.
+type SectorBuilder struct {
+	dir        string
+	stagingDir string
+	sealedDir  string
+
+	lk    sync.Mutex
+	state state
+}
+
+// Open opens the mock sector builder in the directory cfg.Dir.
+func Open(cfg backend.Config) (backend.SectorBuilder, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("mock backend needs a directory")
+	}
+	sectorSize := cfg.SectorSize
+	if sectorSize == 0 {
+		sectorSize = DefaultSectorSize
+	}
+
+	dir := filepath.Join(cfg.Dir, Name)
+	sb := &SectorBuilder{
+		dir:        dir,
+		stagingDir: filepath.Join(dir, "staging"),
+		sealedDir:  filepath.Join(dir, "sealed"),
+	}
+	for _, d := range []string{sb.stagingDir, sb.sealedDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateFileName))
+	if os.IsNotExist(err) {
+		sb.state.SectorSize = sectorSize
+		return sb, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &sb.state)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode mock state")
+	}
+	if sb.state.SectorSize != sectorSize {
+		return nil, fmt.Errorf("mock sectors have size %d, but %d is configured", sb.state.SectorSize, sectorSize)
+	}
+	return sb, nil
+}
+
+func (sb *SectorBuilder) saveState() error {
+	b, err := json.MarshalIndent(&sb.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(sb.dir, stateFileName+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(sb.dir, stateFileName))
+}
+
+func (sb *SectorBuilder) stagedPath(sectorID uint64) string {
+	return filepath.Join(sb.stagingDir, fmt.Sprint(sectorID))
+}
+
+func (sb *SectorBuilder) sealedPath(sectorID uint64) string {
+	return filepath.Join(sb.sealedDir, fmt.Sprint(sectorID))
+}
+
+// AddPiece packs the piece into the first staged sector with enough room left,
+// or a new staged sector.
+func (sb *SectorBuilder) AddPiece(ctx context.Context, pieceRef string, pieceSize uint64, r io.Reader) (uint64, error) {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+
+	max := MaxBytesPerSector(sb.state.SectorSize)
+	if pieceSize > max {
+		return 0, fmt.Errorf("piece size %d exceeds max bytes per sector %d", pieceSize, max)
+	}
+
+	var sector *stagedSector
+	for _, s := range sb.state.Staged {
+		if s.Used+pieceSize <= max {
+			sector = s
+			break
+		}
+	}
+	newSector := sector == nil
+	if newSector {
+		sector = &stagedSector{SectorID: sb.state.LastSectorID + 1}
+	}
+
+	path := sb.stagedPath(sector.SectorID)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, int64(pieceSize)))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil && uint64(n) != pieceSize {
+		err = fmt.Errorf("read %d piece bytes, expected %d", n, pieceSize)
+	}
+	if err != nil {
+		_ = os.Truncate(path, int64(sector.Used)) // drop the partial piece
+		return 0, err
+	}
+
+	if newSector {
+		sb.state.LastSectorID = sector.SectorID
+		sb.state.Staged = append(sb.state.Staged, sector)
+	}
+	sector.Pieces = append(sector.Pieces, backend.PieceInfo{Ref: pieceRef, Size: pieceSize})
+	sector.Used += pieceSize
+	return sector.SectorID, sb.saveState()
+}
+
+// Seal pads the staged sectors into replica files and derives their
+// commitments and proofs.
+func (sb *SectorBuilder) Seal(ctx context.Context, sectorIDs []uint64) error {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+
+	staged := map[uint64]bool{}
+	for _, s := range sb.state.Staged {
+		staged[s.SectorID] = true
+	}
+	selected := map[uint64]bool{}
+	for _, id := range sectorIDs {
+		if !staged[id] {
+			return fmt.Errorf("sector %d is not an unsealed staged sector", id)
+		}
+		selected[id] = true
+	}
+
+	// The state is saved after each sealed sector, so a failure part way
+	// leaves the sectors sealed so far recorded as sealed.
+	for _, s := range append([]*stagedSector(nil), sb.state.Staged...) {
+		if len(selected) > 0 && !selected[s.SectorID] {
+			continue
+		}
+		sealed, err := sb.sealSector(s)
+		if err != nil {
+			return errors.Wrapf(err, "failed to seal sector %d", s.SectorID)
+		}
+		sb.removeStaged(s.SectorID)
+		sb.state.Sealed = append(sb.state.Sealed, *sealed)
+		err = sb.saveState()
+		if err != nil {
+			return err
+		}
+		err = os.Remove(sb.stagedPath(s.SectorID))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sb *SectorBuilder) removeStaged(sectorID uint64) {
+	for i, s := range sb.state.Staged {
+		if s.SectorID == sectorID {
+			sb.state.Staged = append(sb.state.Staged[:i], sb.state.Staged[i+1:]...)
+			return
+		}
+	}
+}
+
+// sealSector writes the replica of the staged sector. The staged sector data
+// is removed by the caller once the sealed sector is saved.
+func (sb *SectorBuilder) sealSector(s *stagedSector) (*backend.SealedSector, error) {
+	data, err := ioutil.ReadFile(sb.stagedPath(s.SectorID))
+	if os.IsNotExist(err) && len(s.Pieces) > 0 {
+		return nil, fmt.Errorf("staged sector data %s is missing", sb.stagedPath(s.SectorID))
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if uint64(len(data)) < s.Used {
+		return nil, fmt.Errorf("staged sector data %s has %d bytes, expected %d", sb.stagedPath(s.SectorID), len(data), s.Used)
+	}
+	data = data[:s.Used] // drop bytes of a piece whose adding was not saved
+	replica := make([]byte, MaxBytesPerSector(sb.state.SectorSize))
+	copy(replica, data)
+	err = ioutil.WriteFile(sb.sealedPath(s.SectorID), replica, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &backend.SealedSector{
+		SectorID: s.SectorID,
+		ProverID: proverID,
+		CommD:    sha256.Sum256(replica),
+		Pieces:   s.Pieces,
+	}
+	sealed.CommR = commR(sealed.ProverID, sealed.SectorID, sealed.CommD)
+	sealed.CommRStar = commRStar(sealed.CommR, sealed.CommD)
+	sealed.Proof = sealProof(sealed)
+	return sealed, nil
+}
+
+func (sb *SectorBuilder) ListStaged() ([]backend.StagedSector, error) {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+	var staged []backend.StagedSector
+	for _, s := range sb.state.Staged {
+		staged = append(staged, backend.StagedSector{
+			SectorID:    s.SectorID,
+			PiecesKnown: true,
+			Pieces:      s.Pieces,
+		})
+	}
+	return staged, nil
+}
+
+func (sb *SectorBuilder) ListSealed() ([]backend.SealedSector, error) {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+	return append([]backend.SealedSector(nil), sb.state.Sealed...), nil
+}
+
+// GeneratePoSt derives the proof from the CommR recomputed from each replica
+// file, so a damaged replica yields a proof which fails verification unless
+// the sector is declared faulty.
+func (sb *SectorBuilder) GeneratePoSt(challengeSeed [32]byte, sectorIDs []uint64, faults []uint64) ([]byte, error) {
+	sb.lk.Lock()
+	defer sb.lk.Unlock()
+
+	byID := map[uint64]backend.SealedSector{}
+	for _, s := range sb.state.Sealed {
+		byID[s.SectorID] = s
+	}
+	if len(sectorIDs) == 0 {
+		for _, s := range sb.state.Sealed {
+			sectorIDs = append(sectorIDs, s.SectorID)
+		}
+	}
+	var sectors []backend.SealedSector
+	for _, id := range sectorIDs {
+		s, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("sealed sector %d not found", id)
+		}
+		replica, err := ioutil.ReadFile(sb.sealedPath(id))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		s.CommR = commR(s.ProverID, s.SectorID, sha256.Sum256(replica))
+		sectors = append(sectors, s)
+	}
+	return postProof(challengeSeed, sectors, faults)
+}
+
+func (sb *SectorBuilder) Close() error {
+	return nil
+}
+
+// Verifier verifies the fake proofs of the mock sector builder.
+type Verifier struct{}
+
+func (Verifier) VerifySeal(sectorSize uint64, s backend.SealedSector) (bool, error) {
+	if s.CommRStar != commRStar(s.CommR, s.CommD) {
+		return false, nil
+	}
+	expected := sealProof(&s)
+	return string(expected) == string(s.Proof), nil
+}
+
+func (Verifier) VerifyPoSt(sectorSize uint64, challengeSeed [32]byte, sectors []backend.SealedSector, faults []uint64, proof []byte) (bool, error) {
+	expected, err := postProof(challengeSeed, sectors, faults)
+	if err != nil {
+		return false, err
+	}
+	return string(expected) == string(proof), nil
+}
+
+func commR(proverID [31]byte, sectorID uint64, commD [32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("commr"))
+	h.Write(proverID[:])
+	writeUint64(h, sectorID)
+	h.Write(commD[:])
+	return sum(h)
+}
+
+func commRStar(commR, commD [32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("commrstar"))
+	h.Write(commR[:])
+	h.Write(commD[:])
+	return sum(h)
+}
+
+func sealProof(s *backend.SealedSector) []byte {
+	h := sha256.New()
+	h.Write([]byte("porep"))
+	h.Write(s.ProverID[:])
+	writeUint64(h, s.SectorID)
+	h.Write(s.CommD[:])
+	h.Write(s.CommR[:])
+	h.Write(s.CommRStar[:])
+	return h.Sum(nil)
+}
+
+// postProof hashes the challenge seed with the CommR of every sector not
+// declared faulty, in sector ID order.
+func postProof(challengeSeed [32]byte, sectors []backend.SealedSector, faults []uint64) ([]byte, error) {
+	faulty := map[uint64]bool{}
+	for _, id := range faults {
+		faulty[id] = true
+	}
+	sorted := append([]backend.SealedSector(nil), sectors...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].SectorID < sorted[j].SectorID })
+
+	h := sha256.New()
+	h.Write([]byte("post"))
+	h.Write(challengeSeed[:])
+	for _, s := range sorted {
+		writeUint64(h, s.SectorID)
+		if faulty[s.SectorID] {
+			delete(faulty, s.SectorID)
+			h.Write([]byte{1})
+			continue
+		}
+		h.Write([]byte{0})
+		h.Write(s.CommR[:])
+	}
+	for id := range faulty {
+		return nil, fmt.Errorf("faulty sector %d is not challenged", id)
+	}
+	return h.Sum(nil), nil
+}
+
+func writeUint64(w io.Writer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	_, _ = w.Write(b[:])
+}
+
+func sum(h interface{ Sum([]byte) []byte }) (s [32]byte) {
+	copy(s[:], h.Sum(nil))
+	return s
+}
@@ -0,0 +1,253 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+func openTestSectorBuilder(t *testing.T, dir string, sectorSize uint64) *SectorBuilder {
+	t.Helper()
+	b, err := Open(backend.Config{Dir: dir, SectorSize: sectorSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*SectorBuilder)
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "filutil-mock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func addPiece(t *testing.T, sb *SectorBuilder, ref string, size int) uint64 {
+	t.Helper()
+	data := bytes.Repeat([]byte{byte(size)}, size)
+	sectorID, err := sb.AddPiece(context.Background(), ref, uint64(size), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sectorID
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sb := openTestSectorBuilder(t, dir, 1024)
+
+	addPiece(t, sb, "a", 100)
+	addPiece(t, sb, "b", 200)
+	staged, err := sb.ListStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 1 || len(staged[0].Pieces) != 2 || !staged[0].PiecesKnown {
+		t.Fatalf("staged sectors %+v, expected one sector with two pieces", staged)
+	}
+
+	err = sb.Seal(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err = sb.ListStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 0 {
+		t.Fatalf("staged sectors %+v left after sealing", staged)
+	}
+
+	// Reopen to check the state is saved.
+	if err = sb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sb = openTestSectorBuilder(t, dir, 1024)
+	sealed, err := sb.ListSealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != 1 || len(sealed[0].Pieces) != 2 {
+		t.Fatalf("sealed sectors %+v, expected one sector with two pieces", sealed)
+	}
+
+	var v Verifier
+	valid, err := v.VerifySeal(1024, sealed[0])
+	if err != nil || !valid {
+		t.Fatalf("PoRep verification: valid %v, error %v", valid, err)
+	}
+	sealed[0].Proof[0] ^= 0xff
+	if valid, _ = v.VerifySeal(1024, sealed[0]); valid {
+		t.Fatal("PoRep with a damaged proof verified")
+	}
+	sealed[0].Proof[0] ^= 0xff
+
+	seed := [32]byte{1}
+	proof, err := sb.GeneratePoSt(seed, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err = v.VerifyPoSt(1024, seed, sealed, nil, proof)
+	if err != nil || !valid {
+		t.Fatalf("PoSt verification: valid %v, error %v", valid, err)
+	}
+	if valid, _ = v.VerifyPoSt(1024, [32]byte{2}, sealed, nil, proof); valid {
+		t.Fatal("PoSt verified under another challenge seed")
+	}
+}
+
+func TestPacking(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sb := openTestSectorBuilder(t, dir, 1024)
+
+	max := int(MaxBytesPerSector(1024))
+	for _, c := range []struct {
+		size     int
+		sectorID uint64
+	}{
+		{600, 1},
+		{400, 1},        // fits the rest of sector 1
+		{500, 2},        // exceeds sector 1
+		{max - 1000, 1}, // exactly fills sector 1
+		{max - 500, 2},  // exactly fills sector 2
+		{1, 3},          // both are full
+		{max, 4},        // a whole sector does not fit sector 3
+		{max - 1, 3},    // exactly fills sector 3
+	} {
+		sectorID := addPiece(t, sb, "p", c.size)
+		if sectorID != c.sectorID {
+			t.Fatalf("piece of %d bytes added into sector %d, expected %d", c.size, sectorID, c.sectorID)
+		}
+	}
+
+	_, err := sb.AddPiece(context.Background(), "big", uint64(max+1), bytes.NewReader(make([]byte, max+1)))
+	if err == nil || !strings.Contains(err.Error(), "exceeds max bytes per sector") {
+		t.Fatalf("oversize piece error %v", err)
+	}
+
+	_, err = sb.AddPiece(context.Background(), "short", 100, bytes.NewReader(make([]byte, 10)))
+	if err == nil {
+		t.Fatal("adding a piece shorter than its size succeeded")
+	}
+	staged, err := sb.ListStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 4 {
+		t.Fatalf("%d staged sectors, expected 4, a failed piece must not create a sector", len(staged))
+	}
+}
+
+func TestCorruptReplica(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sb := openTestSectorBuilder(t, dir, 1024)
+
+	max := int(MaxBytesPerSector(1024))
+	addPiece(t, sb, "a", max)
+	addPiece(t, sb, "b", max)
+	err := sb.Seal(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sb.ListSealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := sb.sealedPath(2)
+	data, err := ioutil.ReadFile(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	err = ioutil.WriteFile(replica, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v Verifier
+	seed := [32]byte{3}
+	proof, err := sb.GeneratePoSt(seed, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, _ := v.VerifyPoSt(1024, seed, sealed, nil, proof); valid {
+		t.Fatal("PoSt of a corrupted replica verified")
+	}
+
+	faults := []uint64{2}
+	proof, err = sb.GeneratePoSt(seed, nil, faults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := v.VerifyPoSt(1024, seed, sealed, faults, proof)
+	if err != nil || !valid {
+		t.Fatalf("PoSt declaring the corrupted sector faulty: valid %v, error %v", valid, err)
+	}
+}
+
+func TestReopenSectorSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sb := openTestSectorBuilder(t, dir, 1024)
+	addPiece(t, sb, "a", 100)
+	if err := sb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Open(backend.Config{Dir: dir, SectorSize: 2048})
+	if err == nil {
+		t.Fatal("reopening with another sector size succeeded")
+	}
+	openTestSectorBuilder(t, dir, 1024)
+}
+
+func TestSealMissingStagedData(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sb := openTestSectorBuilder(t, dir, 1024)
+
+	max := int(MaxBytesPerSector(1024))
+	addPiece(t, sb, "a", max)
+	addPiece(t, sb, "b", max)
+	err := os.Remove(sb.stagedPath(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sb.Seal(context.Background(), nil)
+	if err == nil {
+		t.Fatal("sealing a sector without staged data succeeded")
+	}
+
+	// Sector 1 sealed before the failure must be saved as sealed, and sector
+	// 2 must stay staged rather than be sealed as zeros.
+	sb = openTestSectorBuilder(t, dir, 1024)
+	sealed, err := sb.ListSealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != 1 || sealed[0].SectorID != 1 {
+		t.Fatalf("sealed sectors %+v, expected sector 1", sealed)
+	}
+	staged, err := sb.ListStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 1 || staged[0].SectorID != 2 {
+		t.Fatalf("staged sectors %+v, expected sector 2", staged)
+	}
+	if err = sb.Seal(context.Background(), nil); err == nil {
+		t.Fatal("sealing a sector without staged data succeeded again")
+	}
+}
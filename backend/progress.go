@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressPollInterval is how often WatchProgress polls the transferred bytes.
+const progressPollInterval = 500 * time.Millisecond
+
+// Progress receives how far a long running data transfer of a sector builder
+// has got, e.g., to print it.
+type Progress interface {
+	// Set records the total transferred bytes so far.
+	Set(done uint64)
+	// Finish records that the transfer completed.
+	Finish()
+}
+
+// ProgressFunc starts receiving the progress of a transfer of total bytes
+// under label.
+type ProgressFunc func(label string, total uint64) Progress
+
+// Start starts receiving the progress of the transfer, which is dropped if f
+// is nil.
+func (f ProgressFunc) Start(label string, total uint64) Progress {
+	if f == nil {
+		return nopProgress{}
+	}
+	return f(label, total)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Set(uint64) {}
+func (nopProgress) Finish()    {}
+
+// ProgressReader wraps r to record the bytes read from it into p.
+func ProgressReader(p Progress, r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r    io.Reader
+	p    Progress
+	done uint64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.done += uint64(n)
+	r.p.Set(r.done)
+	return n, err
+}
+
+// WatchProgress polls the transferred bytes from poll into p until the
+// returned stop function is called. It is used for transfers done out of our
+// sight, e.g., in the proofs library.
+func WatchProgress(p Progress, poll func() uint64) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p.Set(poll())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		p.Set(poll())
+	}
+}
+
+// FormatBytes formats n bytes in binary units.
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,214 @@
+package sectorbuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/meta"
+	fcsectorbuilder "github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/proofs/verification"
+	"github.com/filecoin-project/go-filecoin/types"
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+)
+
+func init() {
+	backend.Register(&backend.Backend{
+		Name:              Name,
+		Open:              Open,
+		MaxBytesPerSector: go_sectorbuilder.GetMaxUserBytesPerStagedSector,
+		Verifier:          Verifier{},
+	})
+}
+
+// RecoverError turns a panic into err, since the go-filecoin sector builders
+// and their metadata helpers panic on most errors.
+func RecoverError(err *error) {
+	if v := recover(); v != nil {
+		*err = fmt.Errorf("%v", v)
+	}
+}
+
+// sectorBuilderBackend adapts SectorBuilder to backend.SectorBuilder.
+type sectorBuilderBackend struct {
+	sb       *SectorBuilder
+	progress backend.ProgressFunc
+}
+
+// Open opens the sector builder of the filutil directory cfg.Dir.
+func Open(cfg backend.Config) (b backend.SectorBuilder, err error) {
+	defer RecoverError(&err)
+	sb, err := OpenSectorBuilder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &sectorBuilderBackend{sb: sb, progress: cfg.Progress}, nil
+}
+
+func (b *sectorBuilderBackend) AddPiece(ctx context.Context, pieceRef string, pieceSize uint64, r io.Reader) (sectorID uint64, err error) {
+	c, err := cid.Decode(pieceRef)
+	if err != nil {
+		return 0, err
+	}
+	progress := b.progress.Start("Adding into sector builder", pieceSize)
+	sectorID, err = b.sb.AddPiece(ctx, c, pieceSize, backend.ProgressReader(progress, r))
+	if err != nil {
+		return 0, err
+	}
+	progress.Finish()
+	err = meta.SetSectorState(b.sb.MetaStore, sectorID, meta.SectorStaged, nil)
+	if err != nil {
+		return 0, err
+	}
+	return sectorID, nil
+}
+
+func (b *sectorBuilderBackend) Seal(ctx context.Context, sectorIDs []uint64) error {
+	return b.SealWithOptions(&backend.SealOptions{Sectors: sectorIDs})
+}
+
+// SealWithOptions seals by opts, of which the sector builder only supports
+// retrying failed sectors.
+func (b *sectorBuilderBackend) SealWithOptions(opts *backend.SealOptions) (err error) {
+	defer RecoverError(&err)
+	if opts.MinFill > 0 {
+		return errors.New("--min-fill is not supported by the sector builder, which does not expose the fill level of staged sectors")
+	}
+	if len(opts.Sectors) > 0 {
+		return errors.New("--sector is not supported by the sector builder, which seals all staged sectors at once; use simple-sector-builder to seal selected sectors")
+	}
+	if opts.Resume {
+		return errors.New("--resume is not supported by the sector builder, which cannot select the sectors to seal again; seal-sectors without it seals all unsealed staged sectors")
+	}
+	return b.sb.SealSelectedStagedSectors(opts)
+}
+
+// MetaStore returns the meta datastore the sector builder keeps open.
+func (b *sectorBuilderBackend) MetaStore() *meta.Datastore {
+	return b.sb.MetaStore
+}
+
+func (b *sectorBuilderBackend) ListStaged() ([]backend.StagedSector, error) {
+	allStaged, err := b.sb.GetAllStagedSectors()
+	if err != nil {
+		return nil, err
+	}
+	sealed := map[uint64]bool{}
+	for _, s := range SealedSectors(b.sb.MetaStore) {
+		sealed[s.SectorID] = true
+	}
+	var staged []backend.StagedSector
+	for _, s := range allStaged {
+		if !sealed[s.SectorID] {
+			staged = append(staged, backend.StagedSector{SectorID: s.SectorID})
+		}
+	}
+	return staged, nil
+}
+
+func (b *sectorBuilderBackend) ListSealed() ([]backend.SealedSector, error) {
+	proverID := fcsectorbuilder.AddressToProverID(b.sb.MinerAddr)
+	var sealed []backend.SealedSector
+	for _, s := range SealedSectors(b.sb.MetaStore) {
+		var pieces []backend.PieceInfo
+		for _, p := range s.Pieces {
+			pieces = append(pieces, backend.PieceInfo{Ref: p.Ref.String(), Size: p.Size})
+		}
+		sealed = append(sealed, backend.SealedSector{
+			SectorID:  s.SectorID,
+			ProverID:  proverID,
+			CommD:     s.CommD,
+			CommR:     s.CommR,
+			CommRStar: s.CommRStar,
+			Proof:     s.Proof,
+			Pieces:    pieces,
+		})
+	}
+	return sealed, nil
+}
+
+func (b *sectorBuilderBackend) GeneratePoSt(challengeSeed [32]byte, sectorIDs []uint64, faults []uint64) ([]byte, error) {
+	if len(faults) > 0 {
+		return nil, errors.New("the sector builder always generates PoSt without faults")
+	}
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return nil, err
+	}
+	sortedSectorInfo, err := SortedSectorInfo(sealed, sectorIDs)
+	if err != nil {
+		return nil, err
+	}
+	gres, err := b.sb.GeneratePoSt(fcsectorbuilder.GeneratePoStRequest{
+		SortedSectorInfo: sortedSectorInfo,
+		ChallengeSeed:    challengeSeed,
+	})
+	return gres.Proof, err
+}
+
+func (b *sectorBuilderBackend) Close() (err error) {
+	defer RecoverError(&err)
+	b.sb.Close()
+	return nil
+}
+
+// SortedSectorInfo returns the PoSt sector info of the selected sealed
+// sectors, or all sealed sectors if sectorIDs is empty.
+func SortedSectorInfo(sealed []backend.SealedSector, sectorIDs []uint64) (go_sectorbuilder.SortedSectorInfo, error) {
+	byID := map[uint64]backend.SealedSector{}
+	for _, s := range sealed {
+		byID[s.SectorID] = s
+	}
+	if len(sectorIDs) == 0 {
+		for _, s := range sealed {
+			sectorIDs = append(sectorIDs, s.SectorID)
+		}
+	}
+	var sectorInfos []go_sectorbuilder.SectorInfo
+	for _, id := range sectorIDs {
+		s, ok := byID[id]
+		if !ok {
+			return go_sectorbuilder.SortedSectorInfo{}, fmt.Errorf("sealed sector %d not found", id)
+		}
+		sectorInfos = append(sectorInfos, go_sectorbuilder.SectorInfo{SectorID: s.SectorID, CommR: s.CommR})
+	}
+	return go_sectorbuilder.NewSortedSectorInfo(sectorInfos...), nil
+}
+
+// Verifier verifies proofs by the proofs library.
+type Verifier struct{}
+
+func (Verifier) VerifySeal(sectorSize uint64, s backend.SealedSector) (bool, error) {
+	res, err := (&verification.RustVerifier{}).VerifySeal(verification.VerifySealRequest{
+		CommD:      s.CommD,
+		CommR:      s.CommR,
+		CommRStar:  s.CommRStar,
+		Proof:      s.Proof,
+		ProverID:   s.ProverID,
+		SectorID:   s.SectorID,
+		SectorSize: types.NewBytesAmount(sectorSize),
+	})
+	return res.IsValid, err
+}
+
+func (Verifier) VerifyPoSt(sectorSize uint64, challengeSeed [32]byte, sectors []backend.SealedSector, faults []uint64, proof []byte) (bool, error) {
+	if faults == nil {
+		faults = []uint64{}
+	}
+	var sectorInfos []go_sectorbuilder.SectorInfo
+	for _, s := range sectors {
+		sectorInfos = append(sectorInfos, go_sectorbuilder.SectorInfo{SectorID: s.SectorID, CommR: s.CommR})
+	}
+	res, err := (&verification.RustVerifier{}).VerifyPoSt(verification.VerifyPoStRequest{
+		ChallengeSeed:    challengeSeed,
+		SortedSectorInfo: go_sectorbuilder.NewSortedSectorInfo(sectorInfos...),
+		Faults:           faults,
+		Proof:            proof,
+		SectorSize:       types.NewBytesAmount(sectorSize),
+	})
+	return res.IsValid, err
+}
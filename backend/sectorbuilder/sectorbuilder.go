@@ -0,0 +1,330 @@
+// Package sectorbuilder is the sector builder backend of the go-filecoin rust
+// sector builder, which seals all staged sectors at once. Its sealed sector
+// metadata and sector states are kept in the meta datastore of the filutil
+// directory.
+package sectorbuilder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/meta"
+	"github.com/filecoin-project/go-filecoin/address"
+	fcsectorbuilder "github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+)
+
+const Name = "sector-builder"
+
+// DefaultSectorSize is the sector size if the config does not set one.
+var DefaultSectorSize = types.TwoHundredFiftySixMiBSectorSize
+
+// DefaultMinerAddr is the miner the sectors are sealed for if the config does
+// not set one.
+var DefaultMinerAddr = func() address.Address {
+	addr, err := address.NewActorAddress([]byte("filutilminer"))
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}()
+
+var red = color.New(color.FgRed).SprintFunc()
+var blue = color.New(color.FgBlue).SprintFunc()
+var cyan = color.New(color.FgCyan).SprintFunc()
+
+// ParseConfig returns the filutil directory, sector size and miner address of
+// the config, with the defaults filled in.
+func ParseConfig(cfg backend.Config) (dir string, sectorSize *types.BytesAmount, minerAddr address.Address, err error) {
+	if cfg.Dir == "" {
+		return "", nil, address.Undef, errors.New("sector builder backend needs a directory")
+	}
+	sectorSize = DefaultSectorSize
+	if cfg.SectorSize != 0 {
+		sectorSize = types.NewBytesAmount(cfg.SectorSize)
+	}
+	minerAddr = DefaultMinerAddr
+	if cfg.MinerAddr != "" {
+		minerAddr, err = address.NewFromString(cfg.MinerAddr)
+		if err != nil {
+			return "", nil, address.Undef, err
+		}
+	}
+	return cfg.Dir, sectorSize, minerAddr, nil
+}
+
+type SectorBuilder struct {
+	fcsectorbuilder.SectorBuilder
+	MetaStore         *meta.Datastore
+	SectorSize        *types.BytesAmount
+	MaxBytesPerSector *types.BytesAmount
+	MinerAddr         address.Address
+	// lock keeps other processes from the staging and sealed directories.
+	lock *meta.RepoLock
+}
+
+func (sb *SectorBuilder) Close() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for range sb.SectorSealResults() {
+		}
+		wg.Done()
+	}()
+	err := sb.SectorBuilder.Close()
+	if err != nil {
+		panic(err)
+	}
+	wg.Wait()
+	sb.MetaStore.Close()
+	sb.lock.Release()
+}
+
+func (sb *SectorBuilder) SealAllStagedUnsealedSectors() {
+	allStaged, err := sb.GetAllStagedSectors()
+	if err != nil {
+		panic(err)
+	}
+	var sectorIDs []string
+	sectorIDSet := map[uint64]struct{}{}
+	for _, s := range allStaged {
+		sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
+		sectorIDSet[s.SectorID] = struct{}{}
+	}
+
+	allSealed := SealedSectors(sb.MetaStore)
+	var sealedSectorIDs []string
+	for _, s := range allSealed {
+		sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(s.SectorID))
+		delete(sectorIDSet, s.SectorID)
+	}
+
+	fmt.Println("Seal all staged sectors ...")
+	fmt.Printf("  staged sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
+	fmt.Printf("  but sealed sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
+
+	if len(sectorIDSet) == 0 {
+		fmt.Println("No staged sector needs to seal")
+		return
+	}
+
+	for id := range sectorIDSet {
+		err = meta.SetSectorState(sb.MetaStore, id, meta.SectorSealing, nil)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = sb.SealAllStagedSectors(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	t := time.Now()
+	for val := range sb.SectorSealResults() {
+		if _, ok := sectorIDSet[val.SectorID]; !ok {
+			t = time.Now()
+			continue
+		}
+
+		err = sb.HandleSectorSealResult(&val, t)
+		if err != nil {
+			panic(err)
+		}
+
+		delete(sectorIDSet, val.SectorID)
+		if len(sectorIDSet) == 0 {
+			break
+		}
+
+		t = time.Now()
+	}
+}
+
+// SealSelectedStagedSectors seals all unsealed staged sectors, which include
+// the failed sectors to retry if they are still staged. The sector builder
+// seals all staged sectors at once, so opts cannot select sectors.
+func (sb *SectorBuilder) SealSelectedStagedSectors(opts *backend.SealOptions) error {
+	if opts.RetryFailed {
+		ids, err := meta.ResumeSectors(sb.MetaStore, false, true)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			fmt.Println("No sector to retry")
+			return nil
+		}
+		fmt.Printf("Retry sectors %v\n", ids)
+	}
+	sb.SealAllStagedUnsealedSectors()
+	return nil
+}
+
+// HandleSectorSealResult prints and records the result of sealing a sector.
+// It returns an error if the result could not be recorded.
+func (sb *SectorBuilder) HandleSectorSealResult(r *fcsectorbuilder.SectorSealResult, startAt time.Time) error {
+	if r.SealingErr != nil {
+		fmt.Printf("Sealing %s: sector %d, took %v, error %s\n",
+			red("failed"), r.SectorID, time.Since(startAt), red(r.SealingErr))
+		return meta.SetSectorState(sb.MetaStore, r.SectorID, meta.SectorFailed, r.SealingErr)
+	} else if r.SealingResult != nil {
+		fmt.Printf("Sealing %s: sector %d, took %v\n", blue("succeeded"), r.SectorID, time.Since(startAt))
+		for _, pieceInfo := range r.SealingResult.Pieces {
+			fmt.Printf("  Piece %s, size %d\n", cyan(pieceInfo.Ref), pieceInfo.Size)
+		}
+
+		sectorIDStr := fmt.Sprint(r.SectorID)
+		err := sb.MetaStore.Put(datastore.NewKey(meta.LastUsedSectorIDPrefix), []byte(sectorIDStr))
+		if err != nil {
+			fmt.Printf("  Saving LastUsedSectorID %d failed\n", r.SectorID)
+		}
+		bytes, err := cbor.DumpObject(r.SealingResult)
+		if err != nil {
+			panic(err)
+		}
+		err = sb.MetaStore.Put(meta.MakeKey(meta.SealedSectorMetadataPrefix, sectorIDStr), bytes)
+		if err != nil {
+			fmt.Printf("  Saving SealedSectorMetadata %d failed\n", r.SectorID)
+			return meta.SetSectorState(sb.MetaStore, r.SectorID, meta.SectorFailed, errors.Wrap(err, "failed to save sealed sector metadata"))
+		}
+		return meta.SetSectorState(sb.MetaStore, r.SectorID, meta.SectorSealed, nil)
+	}
+	return nil
+}
+
+// OpenSectorBuilder opens the sector builder of the filutil directory of the
+// config.
+func OpenSectorBuilder(cfg backend.Config) (*SectorBuilder, error) {
+	dir, sectorSize, minerAddr, err := ParseConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := meta.LockRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := meta.Open(dir)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	fail := func(err error) (*SectorBuilder, error) {
+		ds.Close()
+		lock.Release()
+		return nil, err
+	}
+	err = meta.CheckSectorSize(ds, sectorSize.Uint64())
+	if err != nil {
+		return fail(err)
+	}
+
+	var lastUsedSectorID uint64
+	v, err := ds.Get(datastore.NewKey(meta.LastUsedSectorIDPrefix))
+	if err == nil {
+		lastUsedSectorID, err = strconv.ParseUint(string(v), 0, 64)
+		if err != nil {
+			return fail(err)
+		}
+	} else if err != datastore.ErrNotFound {
+		return fail(err)
+	}
+
+	stagingDir := filepath.Join(dir, "staging")
+	sealedDir := filepath.Join(dir, "sealed")
+
+	sectorClass := types.NewSectorClass(sectorSize)
+
+	memRepo := repo.NewInMemoryRepo()
+	blockStore := blockstore.NewBlockstore(memRepo.Datastore())
+	blockService := blockservice.New(blockStore, offline.Exchange(blockStore))
+
+	sb, err := fcsectorbuilder.NewRustSectorBuilder(fcsectorbuilder.RustSectorBuilderConfig{
+		BlockService:     blockService, // not used, so just memory repo
+		LastUsedSectorID: lastUsedSectorID,
+		MetadataDir:      filepath.Join(dir, "metadata"),
+		MinerAddr:        minerAddr,
+		SealedSectorDir:  sealedDir,
+		SectorClass:      sectorClass,
+		StagedSectorDir:  stagingDir,
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	max := types.NewBytesAmount(go_sectorbuilder.GetMaxUserBytesPerStagedSector(sectorClass.SectorSize().Uint64()))
+
+	meta.WarnStuckSectors(ds)
+
+	return &SectorBuilder{
+		SectorBuilder:     sb,
+		MetaStore:         ds,
+		SectorSize:        sectorClass.SectorSize(),
+		MaxBytesPerSector: max,
+		MinerAddr:         minerAddr,
+		lock:              lock,
+	}, nil
+}
+
+type SealedSectorMetadataOrder struct {
+}
+
+func (o SealedSectorMetadataOrder) Compare(a, b query.Entry) int {
+	aID, err := strconv.ParseUint(strings.TrimLeft(a.Key, meta.SealedSectorMetadataPrefix+"/"), 0, 64)
+	if err != nil {
+		panic(err)
+	}
+	bID, err := strconv.ParseUint(strings.TrimLeft(a.Key, meta.SealedSectorMetadataPrefix+"/"), 0, 64)
+	if err != nil {
+		panic(err)
+	}
+	if aID < bID {
+		return -1
+	} else if aID > bID {
+		return 1
+	} else {
+		return 0
+	}
+}
+
+// SealedSectors returns the sealed sector metadata the sector builder keeps
+// in the meta datastore.
+func SealedSectors(metaStore *meta.Datastore) []*fcsectorbuilder.SealedSectorMetadata {
+	queryResult, err := metaStore.Query(query.Query{
+		Prefix: meta.SealedSectorMetadataPrefix,
+		Orders: []query.Order{SealedSectorMetadataOrder{}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	var result []*fcsectorbuilder.SealedSectorMetadata
+	for entry := range queryResult.Next() {
+		err = entry.Error
+		if err != nil {
+			panic(err)
+		}
+
+		var m fcsectorbuilder.SealedSectorMetadata
+		err = cbor.DecodeInto(entry.Value, &m)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, &m)
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package simple
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/backend/sectorbuilder"
+	"github.com/filcloud/filutil/meta"
+	fcsectorbuilder "github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+)
+
+func init() {
+	backend.Register(&backend.Backend{
+		Name:              Name,
+		Open:              Open,
+		MaxBytesPerSector: go_sectorbuilder.GetMaxUserBytesPerStagedSector,
+		Verifier:          sectorbuilder.Verifier{},
+	})
+}
+
+// simpleSectorBuilderBackend adapts SectorBuilder to backend.SectorBuilder.
+type simpleSectorBuilderBackend struct {
+	sb          *SectorBuilder
+	maxParallel int
+}
+
+// Open opens the simple sector builder of the filutil directory cfg.Dir.
+func Open(cfg backend.Config) (b backend.SectorBuilder, err error) {
+	defer sectorbuilder.RecoverError(&err)
+	maxParallel := cfg.MaxParallel
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	sb, err := OpenSectorBuilder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &simpleSectorBuilderBackend{sb: sb, maxParallel: maxParallel}, nil
+}
+
+func (b *simpleSectorBuilderBackend) AddPiece(ctx context.Context, pieceRef string, pieceSize uint64, r io.Reader) (uint64, error) {
+	c, err := cid.Decode(pieceRef)
+	if err != nil {
+		return 0, err
+	}
+	return b.sb.AddPiece(ctx, c, pieceSize, r)
+}
+
+func (b *simpleSectorBuilderBackend) AddPieceFile(ctx context.Context, pieceRef string, pieceSize uint64, path string) (uint64, error) {
+	c, err := cid.Decode(pieceRef)
+	if err != nil {
+		return 0, err
+	}
+	return b.sb.AddPieceFromFile(ctx, c, pieceSize, path)
+}
+
+func (b *simpleSectorBuilderBackend) Seal(ctx context.Context, sectorIDs []uint64) error {
+	return b.SealWithOptions(&backend.SealOptions{
+		MaxParallel: b.maxParallel,
+		Order:       backend.SealOrderOldest,
+		Sectors:     sectorIDs,
+	})
+}
+
+// SealWithOptions seals the staged sectors selected and scheduled by opts.
+func (b *simpleSectorBuilderBackend) SealWithOptions(opts *backend.SealOptions) error {
+	return b.sb.SealAllStagedUnsealedSectors(*opts)
+}
+
+// MetaStore returns the meta datastore the sector builder keeps open.
+func (b *simpleSectorBuilderBackend) MetaStore() *meta.Datastore {
+	return b.sb.MetaStore
+}
+
+// ReplicaPath returns the replica file of the sealed sector.
+func (b *simpleSectorBuilderBackend) ReplicaPath(sectorID uint64) (string, bool) {
+	sealedMap, _ := b.sb.GetSealed() // ignore error
+	s, ok := sealedMap[sectorID]
+	if !ok {
+		return "", false
+	}
+	return b.sb.SealedSectorPath(s.SectorAccess), true
+}
+
+func (b *simpleSectorBuilderBackend) ListStaged() ([]backend.StagedSector, error) {
+	stagedMap, _ := b.sb.GetStaged() // ignore error
+	sealedMap, _ := b.sb.GetSealed() // ignore error
+	sealed, err := sealedSectorIDs(b.sb.MetaStore, sealedMap)
+	if err != nil {
+		return nil, err
+	}
+	var staged []backend.StagedSector
+	for _, s := range stagedMap {
+		if s.State != sealing_state.Pending || sealed[s.SectorID] {
+			continue
+		}
+		staged = append(staged, backend.StagedSector{
+			SectorID:    s.SectorID,
+			PiecesKnown: true,
+			Pieces:      pieceInfos(s.Pieces),
+		})
+	}
+	sort.Slice(staged, func(i, j int) bool { return staged[i].SectorID < staged[j].SectorID })
+	return staged, nil
+}
+
+func (b *simpleSectorBuilderBackend) ListSealed() ([]backend.SealedSector, error) {
+	sealedMap, _ := b.sb.GetSealed() // ignore error
+	proverID := fcsectorbuilder.AddressToProverID(b.sb.MinerAddr)
+	var sealed []backend.SealedSector
+	for _, s := range sealedMap {
+		sealed = append(sealed, backend.SealedSector{
+			SectorID:  s.SectorID,
+			ProverID:  proverID,
+			CommD:     s.CommD,
+			CommR:     s.CommR,
+			CommRStar: s.CommRStar,
+			Proof:     s.Proof,
+			Pieces:    pieceInfos(s.Pieces),
+		})
+	}
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i].SectorID < sealed[j].SectorID })
+	return sealed, nil
+}
+
+func (b *simpleSectorBuilderBackend) GeneratePoSt(challengeSeed [32]byte, sectorIDs []uint64, faults []uint64) ([]byte, error) {
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return nil, err
+	}
+	sortedSectorInfo, err := sectorbuilder.SortedSectorInfo(sealed, sectorIDs)
+	if err != nil {
+		return nil, err
+	}
+	gres, err := b.sb.GeneratePoSt(fcsectorbuilder.GeneratePoStRequest{
+		SortedSectorInfo: sortedSectorInfo,
+		ChallengeSeed:    challengeSeed,
+	}, faults)
+	return gres.Proof, err
+}
+
+func (b *simpleSectorBuilderBackend) Close() (err error) {
+	defer sectorbuilder.RecoverError(&err)
+	b.sb.Close()
+	return nil
+}
+
+func pieceInfos(pieces []go_sectorbuilder.PieceMetadata) []backend.PieceInfo {
+	var infos []backend.PieceInfo
+	for _, p := range pieces {
+		infos = append(infos, backend.PieceInfo{Ref: p.Key, Size: p.Size})
+	}
+	return infos
+}
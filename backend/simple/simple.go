@@ -0,0 +1,656 @@
+// Package simple is the sector builder backend of the go-filecoin simple
+// sector builder, which seals selected staged sectors in parallel and exposes
+// the sealed replica files. Its sector metadata and sector states are kept in
+// the meta datastore of the filutil directory.
+package simple
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/fatih/color"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/backend/sectorbuilder"
+	"github.com/filcloud/filutil/meta"
+	"github.com/filecoin-project/go-filecoin/address"
+	fcsectorbuilder "github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder/multisectorbuilder"
+	"github.com/filecoin-project/go-filecoin/types"
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+)
+
+const Name = "simple-sector-builder"
+
+var red = color.New(color.FgRed).SprintFunc()
+var blue = color.New(color.FgBlue).SprintFunc()
+var cyan = color.New(color.FgCyan).SprintFunc()
+
+type SectorBuilder struct {
+	ptr               unsafe.Pointer
+	stagingDir        string
+	sealedDir         string
+	sectorManager     *multisectorbuilder.SectorStateManager
+	MetaStore         *meta.Datastore
+	SectorSize        *types.BytesAmount
+	MaxBytesPerSector *types.BytesAmount
+	MinerAddr         address.Address
+	// Progress receives the progress of adding pieces.
+	Progress backend.ProgressFunc
+}
+
+// AddPiece adds the piece read from reader. The sector builder reads pieces
+// from files, so the piece is staged into a temp file first; pieces which are
+// files already are added by AddPieceFromFile without the copy.
+func (sb *SectorBuilder) AddPiece(ctx context.Context, pieceRef cid.Cid, pieceSize uint64, reader io.Reader) (sectorID uint64, err error) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		err1 := os.Remove(file.Name())
+		if err1 != nil && err == nil {
+			err = err1
+		}
+	}()
+
+	progress := sb.Progress.Start("Staging into temp file", pieceSize)
+	n, err := io.Copy(file, backend.ProgressReader(progress, reader))
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return 0, err
+	}
+	progress.Finish()
+
+	if uint64(n) != pieceSize {
+		err = fmt.Errorf("was unable to write all piece bytes to temp file (wrote %dB, pieceSize %dB)", n, pieceSize)
+		return 0, err
+	}
+
+	return sb.AddPieceFromFile(ctx, pieceRef, pieceSize, file.Name())
+}
+
+// AddPieceFromFile adds the piece whose data is the whole file at piecePath.
+func (sb *SectorBuilder) AddPieceFromFile(ctx context.Context, pieceRef cid.Cid, pieceSize uint64, piecePath string) (sectorID uint64, err error) {
+	var staged []multisectorbuilder.StagedSectorMetadata
+	stagedMap, err := sb.sectorManager.GetStaged(sb.MinerAddr)
+	if err == nil {
+		sealedMap, _ := sb.sectorManager.GetSealed(sb.MinerAddr) // ignore error
+		sealed, err := sealedSectorIDs(sb.MetaStore, sealedMap)
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range stagedMap {
+			if s.State == sealing_state.Pending && !sealed[s.SectorID] {
+				staged = append(staged, s)
+			}
+		}
+	}
+
+	sectorID, err = go_sectorbuilder.AddPieceFirst(sb.ptr, sb.MinerAddr.String(), staged, pieceSize, sb.sectorManager.GetNextSectorID(sb.MinerAddr))
+	if err != nil {
+		fmt.Printf("get sector id for adding piece: %s\n", err)
+		return 0, err
+	}
+	var sector multisectorbuilder.StagedSectorMetadata
+	var found bool
+	for _, s := range staged {
+		if sectorID == s.SectorID {
+			found = true
+			sector = s
+			break
+		}
+	}
+	if !found {
+		sector = multisectorbuilder.StagedSectorMetadata{
+			SectorID: sectorID,
+			State:    sealing_state.Pending,
+		}
+	}
+
+	// The sector builder writes Fr32 padded piece data into the staging
+	// directory, so watch it grow to see how far it has got.
+	stagedBefore := dirSize(sb.stagingDir)
+	progress := sb.Progress.Start("Adding into staged sector", pieceSize/127*128)
+	stop := backend.WatchProgress(progress, func() uint64 {
+		size := dirSize(sb.stagingDir)
+		if size < stagedBefore {
+			return 0
+		}
+		return size - stagedBefore
+	})
+	sectorMeta, err := go_sectorbuilder.AddPieceSecond(sb.ptr, sb.MinerAddr.String(), sector, pieceRef.String(), pieceSize, piecePath)
+	stop()
+	if err != nil {
+		return 0, err
+	}
+	progress.Finish()
+
+	sectorMeta.UpdatedAt = time.Now()
+
+	err = sb.sectorManager.PutStaged(sb.MinerAddr, sectorMeta)
+	if err != nil {
+		return 0, err
+	}
+
+	state := meta.SectorStaged
+	if sb.sectorFill(sectorMeta) >= 100 {
+		state = meta.SectorFull
+	}
+	err = meta.SetSectorState(sb.MetaStore, sectorMeta.SectorID, state, nil)
+	if err != nil {
+		return 0, err
+	}
+	return sectorMeta.SectorID, nil
+}
+
+func (sb *SectorBuilder) Close() {
+	go_sectorbuilder.DestroySimpleSectorBuilder(sb.ptr)
+	sb.MetaStore.Close()
+}
+
+// defaultSealMemoryFactor estimates the memory needed to seal a sector as a
+// multiple of the sector size.
+const defaultSealMemoryFactor = 8
+
+// SealAllStagedUnsealedSectors seals all staged sectors by a bounded pool of
+// workers. Failed sectors do not stop the others, and are all reported in the
+// returned error.
+func (sb *SectorBuilder) SealAllStagedUnsealedSectors(opts backend.SealOptions) error {
+	stagedMap, _ := sb.sectorManager.GetStaged(sb.MinerAddr) // ignore error
+	sealedMap, _ := sb.sectorManager.GetSealed(sb.MinerAddr) // ignore error
+	sealed, err := sealedSectorIDs(sb.MetaStore, sealedMap)
+	if err != nil {
+		return err
+	}
+
+	var stagedSectorIDs []string
+	for id := range stagedMap {
+		if !sealed[id] {
+			stagedSectorIDs = append(stagedSectorIDs, fmt.Sprint(id))
+		}
+	}
+	var sealedSectorIDs []string
+	for id := range sealedMap {
+		sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(id))
+	}
+
+	fmt.Println("Seal all staged sectors ...")
+	fmt.Printf("  staged sectors: [%s]\n", blue(strings.Join(stagedSectorIDs, ", ")))
+	fmt.Printf("  sealed sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
+
+	if len(stagedSectorIDs) == 0 {
+		fmt.Println("No staged sector needs to seal")
+		return nil
+	}
+
+	staged, err := sb.selectStagedSectors(stagedMap, sealed, opts)
+	if err != nil {
+		return err
+	}
+	if len(staged) == 0 {
+		fmt.Println("No selected staged sector needs to seal")
+		return nil
+	}
+	err = sortStagedSectors(staged, opts.Order)
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxParallel < 1 {
+		opts.MaxParallel = 1
+	}
+	if opts.MemoryPerSector == 0 {
+		opts.MemoryPerSector = defaultSealMemoryFactor * sb.SectorSize.Uint64()
+	}
+
+	var order []string
+	for _, s := range staged {
+		order = append(order, fmt.Sprint(s.SectorID))
+	}
+	fmt.Printf("  sealing order (%s first): [%s], max parallel %d, memory per sector %s\n",
+		opts.Order, blue(strings.Join(order, ", ")), opts.MaxParallel, backend.FormatBytes(opts.MemoryPerSector))
+
+	var lk sync.Mutex
+	failures := map[uint64]error{}
+	running := 0
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, opts.MaxParallel)
+	for _, stagedSector := range staged {
+		slots <- struct{}{}
+		sb.waitForSealMemory(opts.MemoryPerSector, func() int {
+			lk.Lock()
+			defer lk.Unlock()
+			return running
+		})
+
+		lk.Lock()
+		running++
+		lk.Unlock()
+		wg.Add(1)
+		go func(stagedSector multisectorbuilder.StagedSectorMetadata) {
+			defer func() {
+				lk.Lock()
+				running--
+				lk.Unlock()
+				<-slots
+				wg.Done()
+			}()
+			err := sb.sealStagedSector(stagedSector)
+			if err != nil {
+				lk.Lock()
+				failures[stagedSector.SectorID] = err
+				lk.Unlock()
+			}
+		}(stagedSector)
+	}
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+	var failedIDs []uint64
+	for id := range failures {
+		failedIDs = append(failedIDs, id)
+	}
+	sort.Slice(failedIDs, func(i, j int) bool { return failedIDs[i] < failedIDs[j] })
+	fmt.Printf("Sealing %s for %d of %d sectors:\n", red("failed"), len(failures), len(staged))
+	var ids []string
+	for _, id := range failedIDs {
+		fmt.Printf("  Sector %d: %s\n", id, red(failures[id]))
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return fmt.Errorf("failed to seal sectors [%s]", strings.Join(ids, ", "))
+}
+
+// sealedSectorIDs returns the sectors which are sealed by the sealed sector
+// metadata or the sector states. Staged sectors are kept after sealing, so
+// these must not be sealed again or take more pieces.
+func sealedSectorIDs(ds *meta.Datastore, sealedMap map[uint64]multisectorbuilder.SealedSectorMetadata) (map[uint64]bool, error) {
+	sealed := map[uint64]bool{}
+	for id := range sealedMap {
+		sealed[id] = true
+	}
+	ids, err := meta.SectorsInStates(ds, meta.SectorSealed, meta.SectorVerified, meta.SectorInvalid)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		sealed[id] = true
+	}
+	return sealed, nil
+}
+
+// selectStagedSectors picks the staged sectors to seal by the selected sector
+// IDs and the min fill level, leaving out the sealed ones.
+func (sb *SectorBuilder) selectStagedSectors(stagedMap map[uint64]multisectorbuilder.StagedSectorMetadata, sealed map[uint64]bool, opts backend.SealOptions) ([]multisectorbuilder.StagedSectorMetadata, error) {
+	sectorIDs := opts.Sectors
+	if opts.Resume || opts.RetryFailed {
+		ids, err := meta.ResumeSectors(sb.MetaStore, opts.Resume, opts.RetryFailed)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if _, ok := stagedMap[id]; ok && !sealed[id] {
+				sectorIDs = append(sectorIDs, id)
+			} else {
+				fmt.Printf("  skip sector %d, not staged any more\n", id)
+			}
+		}
+		if len(sectorIDs) == 0 {
+			return nil, nil
+		}
+	}
+
+	var candidates []multisectorbuilder.StagedSectorMetadata
+	if len(sectorIDs) > 0 {
+		for _, id := range sectorIDs {
+			s, ok := stagedMap[id]
+			if !ok {
+				return nil, fmt.Errorf("staged sector %d not found", id)
+			}
+			if sealed[id] {
+				return nil, fmt.Errorf("sector %d is sealed already", id)
+			}
+			candidates = append(candidates, s)
+		}
+	} else {
+		for _, s := range stagedMap {
+			if !sealed[s.SectorID] {
+				candidates = append(candidates, s)
+			}
+		}
+	}
+
+	var staged []multisectorbuilder.StagedSectorMetadata
+	for _, s := range candidates {
+		fill := sb.sectorFill(s)
+		if fill < opts.MinFill {
+			fmt.Printf("  skip sector %d, filled %.1f%% < %.1f%%\n", s.SectorID, fill, opts.MinFill)
+			continue
+		}
+		staged = append(staged, s)
+	}
+	return staged, nil
+}
+
+// sectorFill returns the fill level in percent of a staged sector.
+func (sb *SectorBuilder) sectorFill(s multisectorbuilder.StagedSectorMetadata) float64 {
+	var filled uint64
+	for _, p := range s.Pieces {
+		filled += p.Size
+	}
+	return 100 * float64(filled) / float64(sb.MaxBytesPerSector.Uint64())
+}
+
+func (sb *SectorBuilder) sealStagedSector(stagedSector multisectorbuilder.StagedSectorMetadata) error {
+	id := stagedSector.SectorID
+	start := time.Now()
+	err := meta.SetSectorState(sb.MetaStore, id, meta.SectorSealing, nil)
+	if err != nil {
+		return err
+	}
+	sealedSector, err := go_sectorbuilder.SealStagedSector(sb.ptr, sb.MinerAddr.String(), stagedSector, fcsectorbuilder.AddressToProverID(sb.MinerAddr))
+	if err != nil {
+		fmt.Printf("Sealing %s: sector %d, took %v, error %s\n",
+			red("failed"), id, time.Since(start), red(err))
+		return sb.recordSealFailure(id, err)
+	}
+
+	fmt.Printf("Sealing %s: sector %d, took %v\n", blue("succeeded"), id, time.Since(start))
+	for _, pieceInfo := range sealedSector.Pieces {
+		fmt.Printf("  Piece %s, size %d\n", cyan(pieceInfo.Key), pieceInfo.Size)
+	}
+
+	err = sb.sectorManager.PutSealed(sb.MinerAddr, sealedSector)
+	if err != nil {
+		fmt.Printf("  Saving SealedSectorMetadata %d failed\n", id)
+		return sb.recordSealFailure(id, errors.Wrap(err, "failed to save sealed sector metadata"))
+	}
+	err = meta.SetSectorState(sb.MetaStore, id, meta.SectorSealed, nil)
+	if err != nil {
+		return err
+	}
+	// The staged sector is kept for unsealing, but must take no more pieces.
+	stagedSector.State = sealing_state.Sealed
+	stagedSector.UpdatedAt = time.Now()
+	return sb.sectorManager.PutStaged(sb.MinerAddr, stagedSector)
+}
+
+// recordSealFailure moves the sector into the failed state and returns the
+// sealing error, with the error of saving the state if that failed too.
+func (sb *SectorBuilder) recordSealFailure(sectorID uint64, sealErr error) error {
+	err := meta.SetSectorState(sb.MetaStore, sectorID, meta.SectorFailed, sealErr)
+	if err != nil {
+		return fmt.Errorf("%s, and %s", sealErr, err)
+	}
+	return sealErr
+}
+
+// waitForSealMemory blocks until the available memory is enough to seal one
+// more sector besides the running ones. Running seals may not have allocated
+// their memory yet, so need is reserved for each of them rather than trusting
+// the available memory alone. It never blocks when no sector is being sealed,
+// so that sealing always makes progress.
+func (sb *SectorBuilder) waitForSealMemory(need uint64, running func() int) {
+	warned := false
+	for {
+		n := running()
+		if n == 0 {
+			return
+		}
+		available, err := memAvailable()
+		if err != nil {
+			return
+		}
+		reserved := uint64(n) * need
+		if available >= reserved && available-reserved >= need {
+			return
+		}
+		if !warned {
+			fmt.Printf("Waiting for memory: %s available, %s reserved by %d running seals, %s needed\n",
+				backend.FormatBytes(available), backend.FormatBytes(reserved), n, backend.FormatBytes(need))
+			warned = true
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func sortStagedSectors(staged []multisectorbuilder.StagedSectorMetadata, order string) error {
+	switch order {
+	case backend.SealOrderOldest:
+		sort.Slice(staged, func(i, j int) bool {
+			return staged[i].SectorID < staged[j].SectorID
+		})
+	case backend.SealOrderFullest:
+		filled := func(s multisectorbuilder.StagedSectorMetadata) (n uint64) {
+			for _, p := range s.Pieces {
+				n += p.Size
+			}
+			return n
+		}
+		sort.Slice(staged, func(i, j int) bool {
+			fi, fj := filled(staged[i]), filled(staged[j])
+			if fi != fj {
+				return fi > fj
+			}
+			return staged[i].SectorID < staged[j].SectorID
+		})
+	default:
+		return fmt.Errorf("unknown sealing order %s", order)
+	}
+	return nil
+}
+
+// memAvailable returns the available memory reported by /proc/meminfo.
+func memAvailable() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
+
+// GeneratePoSt generates PoSt of the sectors in r, which are challenged except
+// the declared faulty sectors.
+func (sb *SectorBuilder) GeneratePoSt(r fcsectorbuilder.GeneratePoStRequest, faults []uint64) (fcsectorbuilder.GeneratePoStResponse, error) {
+	sealedSectorsMap, err := sb.sectorManager.GetSealed(sb.MinerAddr)
+	if err != nil {
+		return fcsectorbuilder.GeneratePoStResponse{}, err
+	}
+	sealedSectors := make([]multisectorbuilder.SealedSectorMetadata, 0, len(r.SortedSectorInfo.Values()))
+	for _, info := range r.SortedSectorInfo.Values() {
+		s, ok := sealedSectorsMap[info.SectorID]
+		if !ok {
+			return fcsectorbuilder.GeneratePoStResponse{}, errors.Errorf("sealed sector %d not found", info.SectorID)
+		}
+		sealedSectors = append(sealedSectors, s)
+	}
+
+	for _, id := range faults {
+		if _, ok := sealedSectorsMap[id]; !ok {
+			return fcsectorbuilder.GeneratePoStResponse{}, errors.Errorf("faulty sector %d not found", id)
+		}
+	}
+	if faults == nil {
+		faults = []uint64{}
+	}
+	challenges, err := go_sectorbuilder.GeneratePoStFirst(sb.ptr, r.ChallengeSeed, faults, sealedSectors)
+	if err != nil {
+		return fcsectorbuilder.GeneratePoStResponse{}, err
+	}
+
+	challengedSectors := make([]multisectorbuilder.SealedSectorMetadata, 0, len(challenges))
+	for _, c := range challenges {
+		s, ok := sealedSectorsMap[c.Sector]
+		if !ok {
+			return fcsectorbuilder.GeneratePoStResponse{}, errors.Errorf("sealed sector %d not found", c.Sector)
+		}
+		challengedSectors = append(challengedSectors, s)
+	}
+
+	proof, err := go_sectorbuilder.GeneratePoStSecond(sb.ptr, sb.MinerAddr.String(), challenges, faults, challengedSectors)
+	if err != nil {
+		return fcsectorbuilder.GeneratePoStResponse{}, err
+	}
+	postRep := &fcsectorbuilder.GeneratePoStResponse{
+		Proof: proof,
+	}
+	if len(faults) == 0 {
+		// The cache is keyed by the request, which does not carry faults.
+		_ = sb.sectorManager.PutPoSt(sb.MinerAddr, &r, postRep) // ignore cache error
+	}
+	return *postRep, nil
+}
+
+func (sb *SectorBuilder) ReadPieceFromSealedSector(sectorID uint64, pieceRef cid.Cid) (io.Reader, error) {
+	sealedSectorsMap, err := sb.sectorManager.GetSealed(sb.MinerAddr)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := sealedSectorsMap[sectorID]
+	if !ok {
+		return nil, errors.Errorf("sealed sector %d not found", sectorID)
+	}
+
+	data, err := go_sectorbuilder.ReadPieceFromSealedSectorOfMiner(sb.ptr, sb.MinerAddr.String(), s, pieceRef.String(), fcsectorbuilder.AddressToProverID(sb.MinerAddr))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// GetStaged returns the staged sectors by sector ID, which include the
+// sealed ones.
+func (sb *SectorBuilder) GetStaged() (map[uint64]multisectorbuilder.StagedSectorMetadata, error) {
+	return sb.sectorManager.GetStaged(sb.MinerAddr)
+}
+
+// GetSealed returns the sealed sectors by sector ID.
+func (sb *SectorBuilder) GetSealed() (map[uint64]multisectorbuilder.SealedSectorMetadata, error) {
+	return sb.sectorManager.GetSealed(sb.MinerAddr)
+}
+
+// StagedSectorPath returns the file of the staged sector access.
+func (sb *SectorBuilder) StagedSectorPath(access string) string {
+	return SectorAccessPath(sb.stagingDir, access)
+}
+
+// SealedSectorPath returns the replica file of the sealed sector access.
+func (sb *SectorBuilder) SealedSectorPath(access string) string {
+	return SectorAccessPath(sb.sealedDir, access)
+}
+
+// SectorAccessPath resolves the sector access of a sector builder sector to a
+// file path in dir.
+func SectorAccessPath(dir, access string) string {
+	if access == "" || filepath.IsAbs(access) {
+		return access
+	}
+	return filepath.Join(dir, access)
+}
+
+// dirSize returns the total size of all regular files under dir.
+func dirSize(dir string) uint64 {
+	var size uint64
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// OpenSectorStateManager opens the sector state of the simple sector builder
+// of the miner without initializing the sector builder itself.
+func OpenSectorStateManager(ds *meta.Datastore, minerAddr address.Address) *multisectorbuilder.SectorStateManager {
+	m := multisectorbuilder.NewSectorStateManager(ds.Datastore)
+	err := m.LoadMiner(minerAddr)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// OpenSectorBuilder opens the simple sector builder of the filutil directory
+// of the config.
+func OpenSectorBuilder(cfg backend.Config) (*SectorBuilder, error) {
+	dir, sectorSize, minerAddr, err := sectorbuilder.ParseConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := meta.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = meta.CheckSectorSize(ds, sectorSize.Uint64())
+	if err != nil {
+		ds.Close()
+		return nil, err
+	}
+
+	stagingDir := filepath.Join(dir, "staging")
+	sealedDir := filepath.Join(dir, "sealed")
+
+	sectorClass := types.NewSectorClass(sectorSize)
+
+	ptr, err := go_sectorbuilder.InitSimpleSectorBuilder(
+		sectorClass.SectorSize().Uint64(),
+		uint8(sectorClass.PoRepProofPartitions().Int()),
+		uint8(sectorClass.PoStProofPartitions().Int()),
+		sealedDir,
+		stagingDir,
+		fcsectorbuilder.MaxNumStagedSectors,
+	)
+
+	if err != nil {
+		ds.Close()
+		return nil, err
+	}
+
+	max := types.NewBytesAmount(go_sectorbuilder.GetMaxUserBytesPerStagedSector(sectorClass.SectorSize().Uint64()))
+
+	sb := &SectorBuilder{
+		ptr:               ptr,
+		stagingDir:        stagingDir,
+		sealedDir:         sealedDir,
+		sectorManager:     OpenSectorStateManager(ds, minerAddr),
+		MetaStore:         ds,
+		SectorSize:        sectorClass.SectorSize(),
+		MaxBytesPerSector: max,
+		MinerAddr:         minerAddr,
+		Progress:          cfg.Progress,
+	}
+
+	meta.WarnStuckSectors(ds)
+
+	return sb, nil
+}
@@ -0,0 +1,71 @@
+package simple
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder/multisectorbuilder"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/meta"
+)
+
+func TestSealTwiceSkipsSealedSectors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filutil-seal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ds, err := meta.OpenUnmigrated(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	sb := &SectorBuilder{MetaStore: ds, MaxBytesPerSector: types.NewBytesAmount(1016)}
+	stagedMap := map[uint64]multisectorbuilder.StagedSectorMetadata{}
+	for _, id := range []uint64{1, 2} {
+		stagedMap[id] = multisectorbuilder.StagedSectorMetadata{SectorID: id, State: sealing_state.Pending}
+	}
+	sealedMap := map[uint64]multisectorbuilder.SealedSectorMetadata{}
+
+	seal := func(opts backend.SealOptions) ([]multisectorbuilder.StagedSectorMetadata, error) {
+		sealed, err := sealedSectorIDs(ds, sealedMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sb.selectStagedSectors(stagedMap, sealed, opts)
+	}
+
+	staged, err := seal(backend.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 2 {
+		t.Fatalf("first seal selected %d sectors, expected 2", len(staged))
+	}
+
+	// Sector 1 is sealed by its state, sector 2 only by its sealed metadata,
+	// as sectors sealed before sector states were recorded.
+	if err = meta.SetSectorState(ds, 1, meta.SectorSealed, nil); err != nil {
+		t.Fatal(err)
+	}
+	sealedMap[2] = multisectorbuilder.SealedSectorMetadata{SectorID: 2}
+
+	staged, err = seal(backend.SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 0 {
+		t.Fatalf("second seal selected sealed sectors %v", staged)
+	}
+	for _, id := range []uint64{1, 2} {
+		if _, err = seal(backend.SealOptions{Sectors: []uint64{id}}); err == nil {
+			t.Fatalf("sealing sealed sector %d again succeeded", id)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/ipfs/go-merkledag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	BenchFormatTable = "table"
+	BenchFormatCSV   = "csv"
+	BenchFormatJSON  = "json"
+)
+
+var benchBackends []string
+var benchSectorSizes []string
+var benchSectors []int
+var benchParallel []int
+var benchFormat string
+var benchOutput string
+var benchDir string
+var benchKeep bool
+
+func init() {
+	rootCmd.AddCommand(BenchCmd)
+
+	BenchCmd.Flags().StringSliceVar(&benchBackends, "backend", []string{SectorBuilderCmd.Use, SimpleSectorBuilderCmd.Use}, "The sector builder backends to benchmark, sector-builder, simple-sector-builder or mock")
+	BenchCmd.Flags().StringSliceVar(&benchSectorSizes, "sector-size", []string{"256MiB"}, "The sector sizes to benchmark, 256MiB, 1KiB or bytes")
+	BenchCmd.Flags().IntSliceVar(&benchSectors, "sectors", []int{1}, "The numbers of sectors to benchmark")
+	BenchCmd.Flags().IntSliceVar(&benchParallel, "parallel", []int{1}, "The max numbers of sectors sealed in parallel, only used by simple-sector-builder")
+	BenchCmd.Flags().StringVar(&benchFormat, "format", BenchFormatTable, "The report format, table, csv or json")
+	BenchCmd.Flags().StringVarP(&benchOutput, "output", "o", "", "The file to write the report into, defaults to stdout")
+	BenchCmd.Flags().StringVar(&benchDir, "dir", "", "The directory to create benchmark filutil directories in, defaults to the system temp directory")
+	BenchCmd.Flags().BoolVar(&benchKeep, "keep", false, "Keep benchmark filutil directories")
+}
+
+// BenchCase is one cell of the benchmark matrix.
+type BenchCase struct {
+	Backend    string
+	SectorSize uint64
+	Sectors    int
+	// Parallel is zero for the sector builder, which seals with its own
+	// parallelism.
+	Parallel int
+}
+
+// BenchResult holds the phase timings in seconds of a benchmark case.
+type BenchResult struct {
+	BenchCase
+	AddPiece     float64
+	Seal         float64
+	PoRepVerify  float64
+	PoStGenerate float64
+	PoStVerify   float64
+	// PeakRSS is the peak resident memory of filutil while running the case.
+	PeakRSS   uint64
+	DiskUsage uint64
+	Error     string `json:",omitempty"`
+}
+
+var BenchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark sealing and proving of the sector builders",
+	Long:  "Benchmark sealing and proving over a matrix of sector builders, sector sizes, numbers of sectors and sealing parallelism. Each case runs in a fresh filutil directory, and reports the add-piece, seal, PoRep verify, PoSt generate and verify timings, peak RSS and disk usage.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		switch benchFormat {
+		case BenchFormatTable, BenchFormatCSV, BenchFormatJSON:
+		default:
+			err = fmt.Errorf("invalid format %q, expect table, csv or json", benchFormat)
+			return
+		}
+		cases, err := benchCases()
+		if err != nil {
+			return
+		}
+
+		var results []*BenchResult
+		for i, c := range cases {
+			fmt.Printf("Bench %d/%d: %s, sector size %d, %d sectors, parallel %d\n", i+1, len(cases), c.Backend, c.SectorSize, c.Sectors, c.Parallel)
+			r := runBenchCase(c)
+			if r.Error != "" {
+				fmt.Printf("Bench %d/%d: %s\n", i+1, len(cases), red(r.Error))
+			}
+			results = append(results, r)
+		}
+
+		out := io.Writer(os.Stdout)
+		if benchOutput != "" {
+			var f *os.File
+			f, err = os.Create(benchOutput)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			out = f
+		} else {
+			fmt.Println()
+		}
+		err = writeBenchReport(out, benchFormat, results)
+	},
+}
+
+func benchCases() ([]BenchCase, error) {
+	var cases []BenchCase
+	for _, name := range benchBackends {
+		if _, err := backend.Get(name); err != nil {
+			return nil, err
+		}
+		parallel := benchParallel
+		if name == SectorBuilderCmd.Use {
+			parallel = []int{0}
+		}
+		for _, s := range benchSectorSizes {
+			size, err := parseSectorSize(s)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range benchSectors {
+				if n < 1 {
+					return nil, fmt.Errorf("invalid number of sectors %d", n)
+				}
+				for _, p := range parallel {
+					if p < 0 || (p == 0 && name != SectorBuilderCmd.Use) {
+						return nil, fmt.Errorf("invalid parallel %d", p)
+					}
+					cases = append(cases, BenchCase{
+						Backend:    name,
+						SectorSize: size.Uint64(),
+						Sectors:    n,
+						Parallel:   p,
+					})
+				}
+			}
+		}
+	}
+	return cases, nil
+}
+
+// runBenchCase runs the case in a fresh filutil directory. Panics of the
+// sector builders are recovered into the result error, so that the other
+// cases still run.
+func runBenchCase(c BenchCase) (r *BenchResult) {
+	r = &BenchResult{BenchCase: c}
+
+	dir, err := ioutil.TempDir(benchDir, "filutil-bench-")
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer func() {
+		r.DiskUsage = dirSize(dir)
+		if benchKeep {
+			fmt.Printf("Keep benchmark directory %s\n", dir)
+		} else {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	defer func() {
+		if v := recover(); v != nil {
+			r.Error = fmt.Sprint(v)
+		}
+		r.PeakRSS, _ = peakRSS()
+	}()
+	_ = resetPeakRSS() // peak RSS of the whole run if unsupported
+
+	err = benchBackend(r, dir)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func benchBackend(r *BenchResult, dir string) error {
+	bk, err := backend.Get(r.Backend)
+	if err != nil {
+		return err
+	}
+	b, err := bk.Open(backend.Config{
+		Dir:         dir,
+		SectorSize:  r.SectorSize,
+		MaxParallel: r.Parallel,
+		Progress:    backendProgress,
+	})
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	for i := 0; i < r.Sectors; i++ {
+		data := randomPiece(bk.MaxBytesPerSector(r.SectorSize))
+		t := time.Now()
+		_, err := b.AddPiece(context.Background(), data.Cid().String(), uint64(len(data.RawData())), bytes.NewReader(data.RawData()))
+		if err != nil {
+			return err
+		}
+		r.AddPiece += time.Since(t).Seconds()
+	}
+
+	t := time.Now()
+	err = b.Seal(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	r.Seal = time.Since(t).Seconds()
+
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return err
+	}
+	t = time.Now()
+	for _, s := range sealed {
+		valid, err := bk.Verifier.VerifySeal(r.SectorSize, s)
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify PoRep of sector %d", s.SectorID)
+		}
+		if !valid {
+			return fmt.Errorf("invalid PoRep of sector %d", s.SectorID)
+		}
+	}
+	r.PoRepVerify = time.Since(t).Seconds()
+
+	var seed [32]byte
+	_, err = io.ReadFull(rand.Reader, seed[:])
+	if err != nil {
+		return err
+	}
+	t = time.Now()
+	proof, err := b.GeneratePoSt(seed, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate PoSt")
+	}
+	r.PoStGenerate = time.Since(t).Seconds()
+
+	t = time.Now()
+	valid, err := bk.Verifier.VerifyPoSt(r.SectorSize, seed, sealed, nil, proof)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify PoSt")
+	}
+	if !valid {
+		return errors.New("invalid PoSt")
+	}
+	r.PoStVerify = time.Since(t).Seconds()
+	return nil
+}
+
+// randomPiece generates a piece of random data filling a whole sector.
+func randomPiece(size uint64) *merkledag.RawNode {
+	pieceData := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, pieceData)
+	if err != nil {
+		panic(err)
+	}
+	return merkledag.NewRawNode(pieceData)
+}
+
+// resetPeakRSS resets the peak resident memory of the process reported by
+// peakRSS, which needs Linux 4.0 or later.
+func resetPeakRSS() error {
+	return ioutil.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
+
+// peakRSS returns the peak resident memory reported by /proc/self/status.
+func peakRSS() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "VmHWM:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, errors.New("VmHWM not found in /proc/self/status")
+}
+
+var benchColumns = []string{"Backend", "SectorSize", "Sectors", "Parallel", "AddPiece", "Seal", "PoRepVerify", "PoStGenerate", "PoStVerify", "PeakRSS", "DiskUsage", "Error"}
+
+func writeBenchReport(w io.Writer, format string, results []*BenchResult) error {
+	switch format {
+	case BenchFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case BenchFormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(benchColumns)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			err = cw.Write([]string{
+				r.Backend, fmt.Sprint(r.SectorSize), fmt.Sprint(r.Sectors), fmt.Sprint(r.Parallel),
+				formatSeconds(r.AddPiece), formatSeconds(r.Seal), formatSeconds(r.PoRepVerify),
+				formatSeconds(r.PoStGenerate), formatSeconds(r.PoStVerify),
+				fmt.Sprint(r.PeakRSS), fmt.Sprint(r.DiskUsage), r.Error,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(benchColumns, "\t"))
+		for _, r := range results {
+			parallel := fmt.Sprint(r.Parallel)
+			if r.Parallel == 0 {
+				parallel = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%ss\t%ss\t%ss\t%ss\t%ss\t%s\t%s\t%s\n",
+				r.Backend, backend.FormatBytes(r.SectorSize), r.Sectors, parallel,
+				formatSeconds(r.AddPiece), formatSeconds(r.Seal), formatSeconds(r.PoRepVerify),
+				formatSeconds(r.PoStGenerate), formatSeconds(r.PoStVerify),
+				backend.FormatBytes(r.PeakRSS), backend.FormatBytes(r.DiskUsage), r.Error)
+		}
+		return tw.Flush()
+	}
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
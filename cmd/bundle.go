@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bundleVersion      = 1
+	bundleManifestName = "manifest.json"
+	bundleReplicaDir   = "replicas"
+)
+
+// BundleManifest describes the sealed sectors in a bundle. It is the last
+// entry of the bundle tar, after all replica files, so that replica checksums
+// can be computed while writing.
+type BundleManifest struct {
+	Version    int
+	Builder    string
+	SectorSize uint64
+	CreatedAt  time.Time
+	Sectors    []BundleSector
+}
+
+type BundleSector struct {
+	SectorID uint64
+	// Metadata is the encoded sealed sector metadata, CBOR for the sector
+	// builder and JSON for the simple sector builder.
+	Metadata []byte
+	Replica  *BundleFile `json:",omitempty"`
+}
+
+type BundleFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+type bundleWriter struct {
+	file *os.File
+	tw   *tar.Writer
+}
+
+func createBundle(filename string) (*bundleWriter, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &bundleWriter{
+		file: f,
+		tw:   tar.NewWriter(f),
+	}, nil
+}
+
+// AddReplica copies the sealed replica file into the bundle.
+func (w *bundleWriter) AddReplica(replicaPath string) (*BundleFile, error) {
+	f, err := os.Open(replicaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(replicaPath)
+	err = w.tw.WriteHeader(&tar.Header{
+		Name:    path.Join(bundleReplicaDir, name),
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	progress := newProgress("Exporting replica "+name, uint64(stat.Size()))
+	n, err := io.Copy(io.MultiWriter(w.tw, h), progress.Reader(f))
+	if err != nil {
+		return nil, err
+	}
+	progress.Finish()
+	return &BundleFile{
+		Name:   name,
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Close writes the manifest and closes the bundle.
+func (w *bundleWriter) Close(manifest *BundleManifest) (err error) {
+	defer func() {
+		if err1 := w.file.Close(); err == nil {
+			err = err1
+		}
+	}()
+
+	manifest.Version = bundleVersion
+	manifest.CreatedAt = time.Now()
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = w.tw.WriteHeader(&tar.Header{
+		Name:    bundleManifestName,
+		Mode:    0644,
+		Size:    int64(len(b)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(b)
+	if err != nil {
+		return err
+	}
+	return w.tw.Close()
+}
+
+// readBundle reads the bundle manifest, and extracts replica files into temp
+// files in replicaDir if it is not empty. Replica checksums are verified
+// against the manifest. It returns the temp file paths by replica name, which
+// the caller should move into place or remove.
+func readBundle(filename string, replicaDir string) (manifest *BundleManifest, replicas map[string]string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	replicas = map[string]string{}
+	checksums := map[string]string{}
+	defer func() {
+		if err != nil {
+			removeBundleReplicas(replicas)
+			replicas = nil
+		}
+	}()
+
+	tr := tar.NewReader(f)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case hdr.Name == bundleManifestName:
+			manifest = &BundleManifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to decode bundle manifest")
+			}
+		case path.Dir(hdr.Name) == bundleReplicaDir && replicaDir != "":
+			name := path.Base(hdr.Name)
+			err = checkBundleFileName(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			var tmp *os.File
+			tmp, err = ioutil.TempFile(replicaDir, ".import-"+name+"-")
+			if err != nil {
+				return nil, nil, err
+			}
+			replicas[name] = tmp.Name()
+
+			h := sha256.New()
+			progress := newProgress("Importing replica "+name, uint64(hdr.Size))
+			_, err = io.Copy(io.MultiWriter(tmp, h), progress.Reader(tr))
+			if err1 := tmp.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			progress.Finish()
+			checksums[name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%s is not a sector bundle, manifest not found", filename)
+	}
+	if manifest.Version != bundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	if replicaDir != "" {
+		for _, s := range manifest.Sectors {
+			if s.Replica == nil {
+				continue
+			}
+			checksum, ok := checksums[s.Replica.Name]
+			if !ok {
+				return nil, nil, fmt.Errorf("replica %s of sector %d not found in bundle", s.Replica.Name, s.SectorID)
+			}
+			if checksum != s.Replica.SHA256 {
+				return nil, nil, fmt.Errorf("replica %s of sector %d is corrupted, sha256 %s, expected %s", s.Replica.Name, s.SectorID, checksum, s.Replica.SHA256)
+			}
+		}
+	}
+	return manifest, replicas, nil
+}
+
+// checkBundleFileName checks that a file name from a bundle is a plain name,
+// which cannot escape the directory it is joined with.
+func checkBundleFileName(name string) error {
+	if name == "" || filepath.IsAbs(name) || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid file name %q in bundle", name)
+	}
+	return nil
+}
+
+func removeBundleReplicas(replicas map[string]string) {
+	for _, tmp := range replicas {
+		_ = os.Remove(tmp)
+	}
+}
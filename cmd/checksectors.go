@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/pkg/errors"
+)
+
+var checkSectorsSample bool
+
+// checkReplica confirms that the replica file of a sealed sector exists with
+// the sector size and can be read through.
+func checkReplica(path string, size uint64) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("replica %s is missing", path)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(stat.Size()) != size {
+		return fmt.Errorf("replica %s has size %d, expected %d", path, stat.Size(), size)
+	}
+
+	progress := newProgress("  Reading replica", size)
+	_, err = io.Copy(ioutil.Discard, progress.Reader(f))
+	progress.Finish()
+	if err != nil {
+		return errors.Wrapf(err, "replica %s is unreadable", path)
+	}
+	return nil
+}
+
+// sampleSector samples Merkle challenges of the sector against its CommR, by
+// generating and verifying a PoSt of the sector alone under a random seed.
+func sampleSector(b backend.SectorBuilder, verifier backend.Verifier, s backend.SealedSector) error {
+	var seed [32]byte
+	_, err := io.ReadFull(rand.Reader, seed[:])
+	if err != nil {
+		return err
+	}
+	proof, err := b.GeneratePoSt(seed, []uint64{s.SectorID}, nil)
+	if err != nil {
+		return errors.Wrap(err, "sampling challenges failed")
+	}
+	valid, err := verifier.VerifyPoSt(sectorSize.Uint64(), seed, []backend.SealedSector{s}, nil, proof)
+	if err != nil {
+		return errors.Wrap(err, "verifying sampled challenges failed")
+	}
+	if !valid {
+		return errors.New("sampled challenges do not match CommR")
+	}
+	return nil
+}
+
+// printSectorCheck prints the outcome of checking a sector, and returns
+// whether the sector is faulty.
+func printSectorCheck(sectorID uint64, err error) bool {
+	if err != nil {
+		fmt.Printf("Sector %d: %s, %s\n", sectorID, red("faulty"), err)
+		return true
+	}
+	fmt.Printf("Sector %d: %s\n", sectorID, green("ok"))
+	return false
+}
+
+// printSectorFaults prints the fault list in the form taken by postCmd, the
+// verify PoSt command of the sector builder.
+func printSectorFaults(postCmd string, checked int, faults []uint64) {
+	var ids []string
+	for _, id := range faults {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	fmt.Printf("Checked %d sealed sectors, %d faulty: [%s]\n", checked, len(faults), red(strings.Join(ids, ", ")))
+	if len(faults) > 0 {
+		fmt.Printf("Declare them in PoSt by: filutil %s --fault %s\n", postCmd, strings.Join(ids, ","))
+	}
+}
@@ -0,0 +1,583 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fatih/color"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+const configFile = "config.toml"
+
+const (
+	ConfigSectorSize   = "sector-size"
+	ConfigMinerAddress = "miner-address"
+	ConfigBackend      = "backend"
+	ConfigParallelism  = "parallelism"
+	ConfigOutputFormat = "output-format"
+	ConfigColor        = "color"
+
+	// configProfile is the config key of the active profile.
+	configProfile = "profile"
+	// configProfiles is the config table of the named profiles.
+	configProfiles = "profiles"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+const profileEnvVar = "FILUTIL_PROFILE"
+
+// Sources of config values, from the highest precedence. Command line flags
+// override all of them.
+const (
+	ConfigSourceEnv     = "env"
+	ConfigSourceProfile = "profile"
+	ConfigSourceConfig  = "config"
+	ConfigSourceDefault = "default"
+)
+
+var configProfileName string
+
+func init() {
+	rootCmd.AddCommand(ConfigCmd)
+
+	ConfigCmd.AddCommand(ConfigGetCmd)
+	ConfigCmd.AddCommand(ConfigSetCmd)
+	ConfigCmd.AddCommand(ConfigShowCmd)
+
+	rootCmd.PersistentFlags().StringVar(&configProfileName, "profile", "", "The config profile to use, overrides $"+profileEnvVar+" and the profile in the config")
+}
+
+// hookConfig makes every command of the tree apply the config once its flags
+// are parsed. Cobra runs only the nearest PersistentPreRun, so a hook on the
+// root alone is skipped by commands defining their own; each command gets the
+// hook instead, which then runs the nearest PersistentPreRun defined by the
+// commands.
+func hookConfig(root *cobra.Command) {
+	defined := map[*cobra.Command]func(*cobra.Command, []string){}
+	var hook func(c *cobra.Command)
+	hook = func(c *cobra.Command) {
+		if c.PersistentPreRunE != nil {
+			runE := c.PersistentPreRunE
+			defined[c] = func(cmd *cobra.Command, args []string) {
+				exitOnError(runE(cmd, args))
+			}
+		} else if c.PersistentPreRun != nil {
+			defined[c] = c.PersistentPreRun
+		}
+		c.PersistentPreRunE = nil
+		c.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+			exitOnError(applyConfig(cmd))
+			for p := cmd; p != nil; p = p.Parent() {
+				if run, ok := defined[p]; ok {
+					run(cmd, args)
+					return
+				}
+			}
+		}
+		for _, sub := range c.Commands() {
+			hook(sub)
+		}
+	}
+	hook(root)
+}
+
+// ConfigKey is a setting of config.toml, which the environment variable Env
+// overrides.
+type ConfigKey struct {
+	Name    string
+	Env     string
+	Default string
+	// Int tells whether the value is stored as a TOML integer.
+	Int      bool
+	Validate func(v string) error
+}
+
+var configKeys = []ConfigKey{
+	{
+		Name:    ConfigSectorSize,
+		Env:     "FILUTIL_SECTOR_SIZE",
+		Default: "256MiB",
+		Validate: func(v string) error {
+			_, err := parseSectorSize(v)
+			return err
+		},
+	},
+	{
+		Name: ConfigMinerAddress,
+		Env:  "FILUTIL_MINER_ADDRESS",
+		Validate: func(v string) error {
+			if v == "" {
+				return nil
+			}
+			_, err := address.NewFromString(v)
+			return err
+		},
+	},
+	{
+		Name:    ConfigBackend,
+		Env:     "FILUTIL_BACKEND",
+		Default: SectorBuilderCmd.Use,
+		Validate: func(v string) error {
+			_, err := backend.Get(v)
+			return err
+		},
+	},
+	{
+		Name:    ConfigParallelism,
+		Env:     "FILUTIL_PARALLELISM",
+		Default: "1",
+		Int:     true,
+		Validate: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return fmt.Errorf("expect a positive integer")
+			}
+			return nil
+		},
+	},
+	{
+		Name:    ConfigOutputFormat,
+		Env:     "FILUTIL_OUTPUT_FORMAT",
+		Default: BenchFormatTable,
+		Validate: func(v string) error {
+			switch v {
+			case BenchFormatTable, BenchFormatCSV, BenchFormatJSON:
+				return nil
+			}
+			return fmt.Errorf("expect table, csv or json")
+		},
+	},
+	{
+		Name:    ConfigColor,
+		Env:     "FILUTIL_COLOR",
+		Default: ColorAuto,
+		Validate: func(v string) error {
+			switch v {
+			case ColorAuto, ColorAlways, ColorNever:
+				return nil
+			}
+			return fmt.Errorf("expect auto, always or never")
+		},
+	},
+}
+
+func getConfigKey(name string) (ConfigKey, error) {
+	for _, k := range configKeys {
+		if k.Name == name {
+			return k, nil
+		}
+	}
+	return ConfigKey{}, fmt.Errorf("unknown config key %q", name)
+}
+
+// configFlag is a command flag which overrides a config key.
+type configFlag struct {
+	Key  string
+	Cmd  *cobra.Command
+	Flag string
+}
+
+// configFlags returns the flags overriding config keys. A flag applies to its
+// command and the subcommands.
+func configFlags() []configFlag {
+	return []configFlag{
+		{ConfigBackend, SectorsCmd, "backend"},
+		{ConfigBackend, DaemonCmd, "builder"},
+		{ConfigParallelism, SectorsCmd, "max-parallel"},
+		{ConfigParallelism, SimpleSectorBuilderSealSectorsCmd, "max-parallel"},
+		{ConfigOutputFormat, BenchCmd, "format"},
+		{ConfigOutputFormat, StatusCmd, "format"},
+	}
+}
+
+// Config is the config.toml of the filutil directory.
+type Config struct {
+	path   string
+	values map[string]interface{}
+}
+
+// loadConfig loads the config of the filutil directory. A missing config is
+// empty, so every key has its default.
+func loadConfig() (*Config, error) {
+	c := &Config{
+		path:   filepath.Join(getFilutilDir(), configFile),
+		values: map[string]interface{}{},
+	}
+	_, err := toml.DecodeFile(c.path, &c.values)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", c.path, err)
+	}
+	return c, nil
+}
+
+// profile returns the values of the named profile.
+func (c *Config) profile(name string) (map[string]interface{}, error) {
+	profiles, _ := c.values[configProfiles].(map[string]interface{})
+	values, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config profile %q not found in %s", name, c.path)
+	}
+	return values, nil
+}
+
+// profiles returns the names of the profiles.
+func (c *Config) profiles() []string {
+	profiles, _ := c.values[configProfiles].(map[string]interface{})
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// activeProfile returns the name of the active profile, by --profile,
+// $FILUTIL_PROFILE or the profile key, or "" if none.
+func (c *Config) activeProfile() string {
+	if configProfileName != "" {
+		return configProfileName
+	}
+	if name := os.Getenv(profileEnvVar); name != "" {
+		return name
+	}
+	name, _ := c.values[configProfile].(string)
+	return name
+}
+
+// Get returns the value of the key and its source, by the precedence of the
+// environment variable, the active profile, the config and the default.
+func (c *Config) Get(key ConfigKey) (value, source string, err error) {
+	if v, ok := os.LookupEnv(key.Env); ok {
+		return v, ConfigSourceEnv, nil
+	}
+	if name := c.activeProfile(); name != "" {
+		values, err := c.profile(name)
+		if err != nil {
+			return "", "", err
+		}
+		if v, ok := values[key.Name]; ok {
+			return fmt.Sprint(v), ConfigSourceProfile, nil
+		}
+	}
+	if v, ok := c.values[key.Name]; ok {
+		return fmt.Sprint(v), ConfigSourceConfig, nil
+	}
+	return key.Default, ConfigSourceDefault, nil
+}
+
+// Set sets the value of the key in the named profile, or at the top level if
+// profile is "". An empty value removes the key.
+func (c *Config) Set(profile string, key ConfigKey, value string) error {
+	values := c.values
+	if profile != "" {
+		profiles, ok := c.values[configProfiles].(map[string]interface{})
+		if !ok {
+			profiles = map[string]interface{}{}
+			c.values[configProfiles] = profiles
+		}
+		values, ok = profiles[profile].(map[string]interface{})
+		if !ok {
+			values = map[string]interface{}{}
+			profiles[profile] = values
+		}
+	}
+	if value == "" {
+		delete(values, key.Name)
+		return nil
+	}
+	if err := key.Validate(value); err != nil {
+		return fmt.Errorf("invalid %s %q: %s", key.Name, value, err)
+	}
+	if key.Int {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		values[key.Name] = n
+	} else {
+		values[key.Name] = value
+	}
+	return nil
+}
+
+// Save writes the config, replacing the old one at once.
+func (c *Config) Save() error {
+	var buf bytes.Buffer
+	buf.WriteString(configHeader)
+	err := toml.NewEncoder(&buf).Encode(c.values)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+const configHeader = `# filutil config. Values are overridden by the active profile, then by the
+# FILUTIL_* environment variables, then by command line flags. A profile is a
+# [profiles.NAME] table, made active by the profile key, $FILUTIL_PROFILE or
+# --profile. The backend key selects the sector builder of the sectors and
+# daemon commands, the sector-builder and simple-sector-builder commands always
+# run on their own.
+
+`
+
+// writeDefaultConfig writes the config with the defaults into a new filutil
+// directory.
+func writeDefaultConfig() error {
+	c := &Config{
+		path:   filepath.Join(getFilutilDir(), configFile),
+		values: map[string]interface{}{},
+	}
+	for _, key := range configKeys {
+		if key.Default == "" {
+			continue
+		}
+		err := c.Set("", key, key.Default)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Save()
+}
+
+// applyConfig applies the config to the process-wide settings, and to the
+// flags of cmd not set on the command line.
+func applyConfig(cmd *cobra.Command) error {
+	if isCommandOf(cmd, ConfigCmd) {
+		return nil // the config commands read the config themselves
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	get := func(name string) (string, error) {
+		key, err := getConfigKey(name)
+		if err != nil {
+			return "", err
+		}
+		v, source, err := c.Get(key)
+		if err != nil {
+			return "", err
+		}
+		if err = key.Validate(v); err != nil {
+			return "", fmt.Errorf("invalid %s %q from %s: %s", key.Name, v, source, err)
+		}
+		return v, nil
+	}
+
+	v, err := get(ConfigColor)
+	if err != nil {
+		return err
+	}
+	switch v {
+	case ColorAlways:
+		color.NoColor = false
+	case ColorNever:
+		color.NoColor = true
+	}
+
+	v, err = get(ConfigSectorSize)
+	if err != nil {
+		return err
+	}
+	sectorSize, err = parseSectorSize(v)
+	if err != nil {
+		return err
+	}
+
+	v, err = get(ConfigMinerAddress)
+	if err != nil {
+		return err
+	}
+	if v != "" {
+		minerAddr, err = address.NewFromString(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, builder := range []*cobra.Command{SectorBuilderCmd, SimpleSectorBuilderCmd} {
+		if !isCommandOf(cmd, builder) {
+			continue
+		}
+		key, _ := getConfigKey(ConfigBackend)
+		v, source, err := c.Get(key)
+		if err != nil {
+			return err
+		}
+		// New configs hold the default backend, which is no choice to warn
+		// about.
+		if v != key.Default && v != builder.Use {
+			fmt.Printf("%s %s %s from %s is ignored by the %s commands, use the sectors commands to run on it\n",
+				yellow("Warning:"), ConfigBackend, v, source, builder.Use)
+		}
+	}
+
+	for _, cf := range configFlags() {
+		if !isCommandOf(cmd, cf.Cmd) {
+			continue
+		}
+		f := cmd.Flags().Lookup(cf.Flag)
+		if f == nil || f.Changed {
+			continue
+		}
+		v, err := get(cf.Key)
+		if err != nil {
+			return err
+		}
+		err = setFlagDefault(f, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlagDefault sets the value of the flag without marking it as changed.
+func setFlagDefault(f *pflag.Flag, v string) error {
+	err := f.Value.Set(v)
+	if err != nil {
+		return fmt.Errorf("invalid --%s %q: %s", f.Name, v, err)
+	}
+	f.Changed = false
+	return nil
+}
+
+// isCommandOf tells whether cmd is parent or one of its subcommands.
+func isCommandOf(cmd, parent *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if cmd == parent {
+			return true
+		}
+	}
+	return false
+}
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Commands for the filutil config",
+	Long:  "Commands for config.toml of the filutil directory. Values are taken from command line flags, then FILUTIL_* environment variables, then the active profile, then the config, then the defaults.",
+}
+
+var ConfigGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a config key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		v, err := getConfig(args[0])
+		exitOnError(err)
+		fmt.Println(v)
+	},
+}
+
+// getConfig returns the value of the named config key by the precedence of
+// the config sources.
+func getConfig(name string) (string, error) {
+	key, err := getConfigKey(name)
+	if err != nil {
+		return "", err
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	v, _, err := c.Get(key)
+	return v, err
+}
+
+var ConfigSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a config key",
+	Long:  "Set a config key, in the profile if --profile is given. An empty value removes the key. The key profile sets the active profile.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(setConfig(configProfileName, args[0], args[1]))
+	},
+}
+
+// setConfig sets the named config key in the profile, or at the top level if
+// profile is "", and saves the config. The key profile sets the active
+// profile, which must exist.
+func setConfig(profile, name, value string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if name == configProfile {
+		if value == "" {
+			delete(c.values, configProfile)
+		} else {
+			if _, err = c.profile(value); err != nil {
+				return err
+			}
+			c.values[configProfile] = value
+		}
+	} else {
+		key, err := getConfigKey(name)
+		if err != nil {
+			return err
+		}
+		err = c.Set(profile, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Save()
+}
+
+var ConfigShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the config values and where they are from",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		c, err := loadConfig()
+		if err != nil {
+			return
+		}
+		fmt.Printf("Config: %s\n", c.path)
+		if name := c.activeProfile(); name != "" {
+			fmt.Printf("Profile: %s\n", cyan(name))
+		}
+		if names := c.profiles(); len(names) > 0 {
+			fmt.Printf("Profiles: %v\n", names)
+		}
+		for _, key := range configKeys {
+			var v, source string
+			v, source, err = c.Get(key)
+			if err != nil {
+				return
+			}
+			if v == "" {
+				v = "(none)"
+			}
+			fmt.Printf("%s = %s (%s)\n", key.Name, blue(v), source)
+		}
+	},
+}
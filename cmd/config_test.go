@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/spf13/cobra"
+)
+
+// withConfigDir runs the test on a new filutil directory with the default
+// config, and no config environment variables.
+func withConfigDir(t *testing.T, test func(dir string)) {
+	dir, err := ioutil.TempDir("", "filutil-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(dir string, size *types.BytesAmount, profile string) {
+		filutilDir, sectorSize, configProfileName = dir, size, profile
+	}(filutilDir, sectorSize, configProfileName)
+	filutilDir, configProfileName = dir, ""
+	for _, env := range []string{profileEnvVar, "FILUTIL_PARALLELISM", "FILUTIL_BACKEND", "FILUTIL_OUTPUT_FORMAT"} {
+		if err = os.Unsetenv(env); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writeDefaultConfig(); err != nil {
+		t.Fatal(err)
+	}
+	test(dir)
+}
+
+func expectConfig(t *testing.T, name, value, source string) {
+	t.Helper()
+	key, err := getConfigKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, s, err := c.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != value || s != source {
+		t.Fatalf("%s is %q from %s, expected %q from %s", name, v, s, value, source)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	withConfigDir(t, func(dir string) {
+		expectConfig(t, ConfigParallelism, "1", ConfigSourceConfig)
+
+		// A key missing from the config has its default.
+		if err := setConfig("", ConfigParallelism, ""); err != nil {
+			t.Fatal(err)
+		}
+		expectConfig(t, ConfigParallelism, "1", ConfigSourceDefault)
+
+		if err := setConfig("", ConfigParallelism, "2"); err != nil {
+			t.Fatal(err)
+		}
+		expectConfig(t, ConfigParallelism, "2", ConfigSourceConfig)
+
+		if err := setConfig("fast", ConfigParallelism, "4"); err != nil {
+			t.Fatal(err)
+		}
+		expectConfig(t, ConfigParallelism, "2", ConfigSourceConfig)
+		if err := setConfig("", configProfile, "fast"); err != nil {
+			t.Fatal(err)
+		}
+		expectConfig(t, ConfigParallelism, "4", ConfigSourceProfile)
+
+		if err := os.Setenv("FILUTIL_PARALLELISM", "8"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv("FILUTIL_PARALLELISM")
+		expectConfig(t, ConfigParallelism, "8", ConfigSourceEnv)
+
+		// Flags not given take the config, given flags override it.
+		f := SectorsCmd.PersistentFlags().Lookup("max-parallel")
+		defer func() {
+			_ = setFlagDefault(f, f.DefValue)
+		}()
+		if err := SectorsLsCmd.ParseFlags(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(SectorsLsCmd); err != nil {
+			t.Fatal(err)
+		}
+		if sectorsMaxParallel != 8 {
+			t.Fatalf("--max-parallel is %d from the config, expected 8", sectorsMaxParallel)
+		}
+		if err := SectorsLsCmd.ParseFlags([]string{"--max-parallel", "16"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(SectorsLsCmd); err != nil {
+			t.Fatal(err)
+		}
+		if sectorsMaxParallel != 16 {
+			t.Fatalf("--max-parallel is %d, expected the flag 16", sectorsMaxParallel)
+		}
+	})
+}
+
+func TestConfigSetGet(t *testing.T) {
+	withConfigDir(t, func(dir string) {
+		if err := setConfig("", ConfigParallelism, "3"); err != nil {
+			t.Fatal(err)
+		}
+		v, err := getConfig(ConfigParallelism)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != "3" {
+			t.Fatalf("parallelism is %q, expected 3", v)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, configFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "parallelism = 3\n") {
+			t.Fatalf("parallelism is not saved as an integer:\n%s", data)
+		}
+
+		for _, c := range []struct{ profile, name, value string }{
+			{"", "unknown", "1"},
+			{"", ConfigParallelism, "0"},
+			{"", ConfigBackend, "unknown"},
+			{"", ConfigOutputFormat, "xml"},
+			{"fast", ConfigColor, "sometimes"},
+			{"", configProfile, "missing"},
+		} {
+			if err = setConfig(c.profile, c.name, c.value); err == nil {
+				t.Fatalf("setting %s %q in profile %q succeeded", c.name, c.value, c.profile)
+			}
+		}
+		v, err = getConfig(ConfigParallelism)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != "3" {
+			t.Fatalf("parallelism is %q after invalid sets, expected 3", v)
+		}
+
+		if _, err = getConfig("unknown"); err == nil {
+			t.Fatal("getting an unknown key succeeded")
+		}
+	})
+}
+
+func TestHookConfig(t *testing.T) {
+	withConfigDir(t, func(dir string) {
+		if err := setConfig("", ConfigSectorSize, "2048"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The config is applied under a command defining its own
+		// PersistentPreRun too, which still runs for its subcommands.
+		var ran bool
+		root := &cobra.Command{Use: "filutil"}
+		parent := &cobra.Command{
+			Use:              "parent",
+			PersistentPreRun: func(cmd *cobra.Command, args []string) { ran = true },
+		}
+		parent.AddCommand(&cobra.Command{
+			Use: "child",
+			Run: func(cmd *cobra.Command, args []string) {},
+		})
+		root.AddCommand(parent)
+		hookConfig(root)
+
+		root.SetArgs([]string{"parent", "child"})
+		if err := root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !ran {
+			t.Fatal("PersistentPreRun of the parent did not run")
+		}
+		if sectorSize.Uint64() != 2048 {
+			t.Fatalf("sector size is %d, expected 2048 from the config", sectorSize.Uint64())
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	CorruptBitFlip  = "bit-flip"
+	CorruptTruncate = "truncate"
+	CorruptDelete   = "delete"
+)
+
+var corruptMode string
+var corruptOffset int64
+var corruptTruncateTo int64
+var corruptReplicaPath string
+
+// addCorruptFlags adds the flags shared by the corrupt-sector commands of both
+// sector builders.
+func addCorruptFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&corruptMode, "mode", CorruptBitFlip, "How to damage the replica, bit-flip, truncate or delete")
+	cmd.Flags().Int64Var(&corruptOffset, "offset", -1, "The offset of the byte to flip a bit in, defaults to the middle of the replica")
+	cmd.Flags().Int64Var(&corruptTruncateTo, "truncate-to", -1, "The size to truncate the replica to, defaults to half of its size")
+	cmd.Flags().StringVar(&corruptReplicaPath, "replica", "", "The path of the sealed replica file")
+}
+
+// corruptReplica deliberately damages a sealed replica file, so that PoSt
+// fault detection can be tested. A negative offset flips a bit in the middle
+// of the file, and a negative truncateTo truncates the file to half its size.
+func corruptReplica(path, mode string, offset, truncateTo int64) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case CorruptBitFlip:
+		if offset < 0 {
+			offset = stat.Size() / 2
+		}
+		if offset >= stat.Size() {
+			return fmt.Errorf("offset %d is beyond replica size %d", offset, stat.Size())
+		}
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, offset)
+		if err != nil {
+			return err
+		}
+		flipped := b[0] ^ 1
+		_, err = f.WriteAt([]byte{flipped}, offset)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Flipped bit at offset %d of %s: %#02x -> %#02x\n", offset, path, b[0], flipped)
+		return f.Close()
+	case CorruptTruncate:
+		if truncateTo < 0 {
+			truncateTo = stat.Size() / 2
+		}
+		if truncateTo >= stat.Size() {
+			return fmt.Errorf("truncating to %d does not shrink replica of size %d", truncateTo, stat.Size())
+		}
+		err = os.Truncate(path, truncateTo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Truncated %s from %d to %d bytes\n", path, stat.Size(), truncateTo)
+		return nil
+	case CorruptDelete:
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted %s\n", path)
+		return nil
+	default:
+		return fmt.Errorf("invalid corruption mode %q, expect %s, %s or %s", mode, CorruptBitFlip, CorruptTruncate, CorruptDelete)
+	}
+}
@@ -0,0 +1,736 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ipfs/go-datastore/query"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/meta"
+)
+
+var daemonBuilder string
+
+func init() {
+	rootCmd.AddCommand(DaemonCmd)
+
+	DaemonCmd.AddCommand(DaemonJobsCmd)
+
+	DaemonCmd.Flags().StringVar(&daemonBuilder, "builder", SectorBuilderCmd.Use, "The sector builder to keep open, sector-builder or simple-sector-builder")
+
+	meta.LockHolderError = func(dir string, pid int) error {
+		if daemonPID(dir) == pid {
+			// The daemon holds the directory for as long as a job runs, so
+			// fail rather than wait behind its queue.
+			return &DaemonBusyError{Dir: dir, PID: pid}
+		}
+		return nil
+	}
+}
+
+const daemonSocketName = "daemon.sock"
+
+const (
+	JobAddPiece = "add-piece"
+	JobSeal     = "seal"
+	JobVerify   = "verify"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of work queued in the daemon. Jobs are persisted in the meta
+// datastore, so queued and interrupted jobs are run again after restart.
+type Job struct {
+	ID         uint64
+	Kind       string
+	Builder    string
+	File       string               `json:",omitempty"`
+	Recursive  bool                 `json:",omitempty"`
+	PerFile    bool                 `json:",omitempty"`
+	Import     *ImportOptions       `json:",omitempty"`
+	NoSeal     bool                 `json:",omitempty"`
+	Seal       *backend.SealOptions `json:",omitempty"`
+	State      string
+	Result     string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Progress is the last progress line of the running job.
+	Progress string `json:",omitempty"`
+}
+
+type DaemonInfo struct {
+	Pid     int
+	Builder string
+}
+
+var DaemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a daemon which runs sector builder jobs in background",
+	Long:  "Run a daemon which runs add-piece, seal and verify jobs of a sector builder in background. It serves JSON-RPC on a Unix socket in the filutil directory, and the add-piece, seal-sectors and verify-sectors-porep commands submit jobs to it while it is running. The sector builder and the stores are only opened while a job runs, so other commands can use the filutil directory between jobs, and fail while a job runs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		if client := dialDaemon(); client != nil {
+			var info DaemonInfo
+			err = client.Call("Daemon.Info", struct{}{}, &info)
+			client.Close()
+			if err == nil {
+				err = fmt.Errorf("daemon is already running with pid %d", info.Pid)
+			}
+			return
+		}
+
+		d := &daemon{
+			builder: daemonBuilder,
+			jobs:    map[uint64]*Job{},
+			done:    map[uint64]chan struct{}{},
+		}
+		d.cond = sync.NewCond(&d.lk)
+
+		switch daemonBuilder {
+		case SectorBuilderCmd.Use, SimpleSectorBuilderCmd.Use:
+		default:
+			err = fmt.Errorf("unknown sector builder %s", daemonBuilder)
+			return
+		}
+		// Jobs wait for the filutil directory while other commands use it,
+		// rather than fail.
+		meta.LockWait = true
+
+		err = d.loadJobs()
+		if err != nil {
+			return
+		}
+
+		server := rpc.NewServer()
+		err = server.RegisterName("Daemon", &DaemonAPI{d: d})
+		if err != nil {
+			return
+		}
+
+		socketPath := filepath.Join(getFilutilDir(), daemonSocketName)
+		_ = os.Remove(socketPath) // stale socket of a dead daemon
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return
+		}
+		defer os.Remove(socketPath)
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+			}
+		}()
+
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			fmt.Println("Stopping daemon after the running job, interrupt again to exit immediately ...")
+			d.stop()
+			listener.Close()
+			<-signals
+			os.Exit(1)
+		}()
+
+		fmt.Printf("Daemon is running with %s, pid %d, listening on %s\n", blue(daemonBuilder), os.Getpid(), socketPath)
+		d.work()
+	},
+}
+
+var DaemonJobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List daemon jobs",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		var jobs []Job
+		if client := dialDaemon(); client != nil {
+			defer client.Close()
+			err = client.Call("Daemon.Jobs", struct{}{}, &jobs)
+			if err != nil {
+				return
+			}
+		} else {
+			var ds *meta.Datastore
+			ds, err = meta.Open(getFilutilDir())
+			if err != nil {
+				return
+			}
+			defer ds.Close()
+			jobs, err = getJobList(ds)
+			if err != nil {
+				return
+			}
+		}
+
+		for i := range jobs {
+			printJob(&jobs[i])
+		}
+	},
+}
+
+type daemon struct {
+	builder string
+
+	// metaLk serializes opening the sector builder and the meta datastore,
+	// which may wait for another process holding the filutil directory, so it
+	// is never taken with lk held.
+	metaLk sync.Mutex
+	// ds is the meta datastore kept by the sector builder of the running
+	// job, or nil between jobs, when the daemon does not hold the filutil
+	// directory. It is guarded by metaLk.
+	ds *meta.Datastore
+
+	// lk guards the jobs and the queue.
+	lk       sync.Mutex
+	cond     *sync.Cond
+	jobs     map[uint64]*Job
+	queue    []uint64
+	nextID   uint64
+	done     map[uint64]chan struct{}
+	stopping bool
+}
+
+// withMeta runs fn with the meta datastore of the running job, or opens the
+// meta datastore for fn between jobs.
+func (d *daemon) withMeta(fn func(ds *meta.Datastore) error) error {
+	d.metaLk.Lock()
+	defer d.metaLk.Unlock()
+	return d.withMetaLocked(fn)
+}
+
+// withMetaLocked is withMeta with d.metaLk held.
+func (d *daemon) withMetaLocked(fn func(ds *meta.Datastore) error) error {
+	if d.ds != nil {
+		return fn(d.ds)
+	}
+	ds, err := meta.Open(getFilutilDir())
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	return fn(ds)
+}
+
+// snapshotJob copies the job under d.lk, to save it without d.lk held.
+func (d *daemon) snapshotJob(job *Job) Job {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return *job
+}
+
+// loadJobs loads persisted jobs, and queues again the jobs which were queued
+// or running when the daemon stopped. It runs before the daemon serves.
+func (d *daemon) loadJobs() error {
+	return d.withMeta(func(ds *meta.Datastore) error {
+		jobs, err := getJobList(ds)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			job := &jobs[i]
+			d.jobs[job.ID] = job
+			if job.ID >= d.nextID {
+				d.nextID = job.ID + 1
+			}
+			if job.State == JobQueued || job.State == JobRunning {
+				if job.Builder != d.builder {
+					job.State = JobFailed
+					job.Error = fmt.Sprintf("daemon restarted with %s", d.builder)
+				} else {
+					job.State = JobQueued
+					d.queue = append(d.queue, job.ID)
+					d.done[job.ID] = make(chan struct{})
+				}
+				err = putJob(ds, job)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(d.queue) > 0 {
+			fmt.Printf("Resuming %d queued jobs\n", len(d.queue))
+		}
+		return nil
+	})
+}
+
+// submit persists and queues the job. Persisting it may wait for the worker
+// opening the sector builder, but not the other calls of the daemon.
+func (d *daemon) submit(job Job) (uint64, error) {
+	d.lk.Lock()
+	if d.stopping {
+		d.lk.Unlock()
+		return 0, fmt.Errorf("daemon is stopping")
+	}
+	if job.Builder != d.builder {
+		d.lk.Unlock()
+		return 0, fmt.Errorf("daemon is running with %s, not %s", d.builder, job.Builder)
+	}
+	job.ID = d.nextID
+	d.nextID++
+	d.lk.Unlock()
+
+	job.State = JobQueued
+	job.CreatedAt = time.Now()
+	err := d.withMeta(func(ds *meta.Datastore) error {
+		return putJob(ds, &job)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.jobs[job.ID] = &job
+	d.queue = append(d.queue, job.ID)
+	d.done[job.ID] = make(chan struct{})
+	d.cond.Signal()
+	return job.ID, nil
+}
+
+func (d *daemon) wait(id uint64) (Job, error) {
+	d.lk.Lock()
+	job, ok := d.jobs[id]
+	if !ok {
+		d.lk.Unlock()
+		return Job{}, fmt.Errorf("job %d not found", id)
+	}
+	done := d.done[id]
+	d.lk.Unlock()
+
+	if done != nil {
+		<-done
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return *job, nil
+}
+
+func (d *daemon) stop() {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.stopping = true
+	d.cond.Broadcast()
+}
+
+// work runs queued jobs one by one until the daemon is stopped.
+func (d *daemon) work() {
+	for {
+		d.lk.Lock()
+		for len(d.queue) == 0 && !d.stopping {
+			d.cond.Wait()
+		}
+		if d.stopping {
+			d.lk.Unlock()
+			return
+		}
+		job := d.jobs[d.queue[0]]
+		d.queue = d.queue[1:]
+		job.State = JobRunning
+		job.StartedAt = time.Now()
+		d.lk.Unlock()
+
+		// Opening the sector builder waits for the filutil directory, so only
+		// the meta datastore is held meanwhile, not the jobs.
+		d.metaLk.Lock()
+		bk, b, openErr := openBackend(d.builder, 1)
+		if openErr == nil {
+			d.ds = metaStoreOf(b)
+		}
+		d.saveJobLocked(job)
+		d.metaLk.Unlock()
+
+		var result string
+		err := openErr
+		if err == nil {
+			fmt.Printf("Running %s job %d\n", job.Kind, job.ID)
+			setProgressSink(func(line string) {
+				d.lk.Lock()
+				defer d.lk.Unlock()
+				job.Progress = line
+			})
+			result, err = d.runJob(job, bk, b)
+			setProgressSink(nil)
+		}
+
+		d.lk.Lock()
+		job.FinishedAt = time.Now()
+		job.Result = result
+		job.Progress = ""
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.State = JobDone
+		}
+		d.lk.Unlock()
+
+		d.metaLk.Lock()
+		d.saveJobLocked(job)
+		if openErr == nil {
+			d.ds = nil
+			if err := b.Close(); err != nil {
+				fmt.Printf("Closing %s failed: %s\n", d.builder, red(err))
+			}
+		}
+		d.metaLk.Unlock()
+
+		d.lk.Lock()
+		printJob(job)
+		close(d.done[job.ID])
+		delete(d.done, job.ID)
+		d.lk.Unlock()
+	}
+}
+
+// saveJobLocked persists the job of the worker with d.metaLk held, and logs
+// failures, which do not fail the job.
+func (d *daemon) saveJobLocked(job *Job) {
+	snapshot := d.snapshotJob(job)
+	err := d.withMetaLocked(func(ds *meta.Datastore) error {
+		return putJob(ds, &snapshot)
+	})
+	if err != nil {
+		fmt.Printf("Saving job %d failed: %s\n", job.ID, red(err))
+	}
+}
+
+// runJob runs the job on the sector builder b opened for it, with the meta
+// datastore d.ds kept by b.
+func (d *daemon) runJob(job *Job, bk *backend.Backend, b backend.SectorBuilder) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	switch job.Kind {
+	case JobAddPiece:
+		var opts ImportOptions
+		if job.Import != nil {
+			opts = *job.Import
+		}
+		dag, err := openSectorBuilderPiecesDAG()
+		if err != nil {
+			return "", err
+		}
+		defer dag.Close()
+		pieces, sectorIDs, err := addBackendPieces(b, dag, d.ds, job.File, job.Recursive, job.PerFile, opts)
+		if err != nil {
+			return "", err
+		}
+		var added []string
+		for i, p := range pieces {
+			added = append(added, fmt.Sprintf("added piece %s into staging sector %d", p.Cid, sectorIDs[i]))
+		}
+		if !job.NoSeal {
+			err = sealBackend(b, &backend.SealOptions{MaxParallel: 1, Order: backend.SealOrderOldest})
+			if err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(added, ", "), nil
+	case JobSeal:
+		opts := sealOptions()
+		if job.Seal != nil {
+			opts = *job.Seal
+		}
+		err = sealBackend(b, &opts)
+		if err != nil {
+			return "", err
+		}
+		return sealJobResult(&opts), nil
+	case JobVerify:
+		failed, err := verifyBackendPoRep(bk, b)
+		if err != nil {
+			return "", err
+		}
+		if failed > 0 {
+			return "", fmt.Errorf("%d sealed sectors failed PoRep verification", failed)
+		}
+		return "all sealed sectors passed PoRep verification", nil
+	default:
+		return "", fmt.Errorf("unknown job kind %s", job.Kind)
+	}
+}
+
+// sealJobResult describes the sectors a seal job sealed by opts.
+func sealJobResult(opts *backend.SealOptions) string {
+	var ids []string
+	for _, id := range opts.Sectors {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	result := "sealed all staged sectors"
+	if len(ids) > 0 {
+		result = fmt.Sprintf("sealed sectors [%s]", strings.Join(ids, ", "))
+	}
+	if opts.MinFill > 0 {
+		result += fmt.Sprintf(" filled at least %g%%", opts.MinFill)
+	}
+	if opts.Resume {
+		result += ", resumed sectors left in sealing"
+	}
+	if opts.RetryFailed {
+		result += ", retried failed sectors"
+	}
+	return result
+}
+
+// DaemonAPI is the JSON-RPC API served by the daemon.
+type DaemonAPI struct {
+	d *daemon
+}
+
+func (a *DaemonAPI) Info(_ struct{}, info *DaemonInfo) error {
+	*info = DaemonInfo{
+		Pid:     os.Getpid(),
+		Builder: a.d.builder,
+	}
+	return nil
+}
+
+func (a *DaemonAPI) Submit(job Job, id *uint64) error {
+	var err error
+	*id, err = a.d.submit(job)
+	return err
+}
+
+// Job returns the current state of the job, with the progress of the running
+// job.
+func (a *DaemonAPI) Job(id uint64, job *Job) error {
+	a.d.lk.Lock()
+	defer a.d.lk.Unlock()
+	j, ok := a.d.jobs[id]
+	if !ok {
+		return fmt.Errorf("job %d not found", id)
+	}
+	*job = *j
+	return nil
+}
+
+func (a *DaemonAPI) Wait(id uint64, job *Job) error {
+	var err error
+	*job, err = a.d.wait(id)
+	return err
+}
+
+func (a *DaemonAPI) Jobs(_ struct{}, jobs *[]Job) error {
+	a.d.lk.Lock()
+	defer a.d.lk.Unlock()
+	for _, job := range a.d.jobs {
+		*jobs = append(*jobs, *job)
+	}
+	sort.Slice(*jobs, func(i, j int) bool {
+		return (*jobs)[i].ID < (*jobs)[j].ID
+	})
+	return nil
+}
+
+// dialDaemon connects to the running daemon, or returns nil if there is none.
+func dialDaemon() *rpc.Client {
+	return dialDaemonOf(getFilutilDir())
+}
+
+func dialDaemonOf(dir string) *rpc.Client {
+	conn, err := net.Dial("unix", filepath.Join(dir, daemonSocketName))
+	if err != nil {
+		return nil
+	}
+	return jsonrpc.NewClient(conn)
+}
+
+// daemonPID returns the PID of the daemon running on the filutil directory
+// dir, or 0 if there is none.
+func daemonPID(dir string) int {
+	client := dialDaemonOf(dir)
+	if client == nil {
+		return 0
+	}
+	defer client.Close()
+	var info DaemonInfo
+	if client.Call("Daemon.Info", struct{}{}, &info) != nil {
+		return 0
+	}
+	return info.Pid
+}
+
+// DaemonBusyError is returned when a command other than the ones submitted
+// to the daemon opens the filutil directory while a daemon job runs.
+type DaemonBusyError struct {
+	Dir string
+	PID int
+}
+
+func (e *DaemonBusyError) Error() string {
+	return fmt.Sprintf("filutil directory %s is in use by a job of the daemon (pid %d). Only add-piece, seal-sectors and verify-sectors-porep of sector-builder and simple-sector-builder run through the daemon; run this command when no job is running, or stop the daemon", e.Dir, e.PID)
+}
+
+// submitDaemonJob submits the job to the running daemon and waits for it to
+// finish. It returns false if there is no daemon, and the caller should run
+// the job by itself.
+func submitDaemonJob(job *Job) bool {
+	client := dialDaemon()
+	if client == nil {
+		return false
+	}
+	defer client.Close()
+
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}()
+
+	var info DaemonInfo
+	err = client.Call("Daemon.Info", struct{}{}, &info)
+	if err != nil {
+		return true
+	}
+	if job.File != "" {
+		job.File, err = filepath.Abs(job.File)
+		if err != nil {
+			return true
+		}
+	}
+
+	var id uint64
+	err = client.Call("Daemon.Submit", job, &id)
+	if err != nil {
+		return true
+	}
+	fmt.Printf("Submitted %s job %d to daemon (pid %d), waiting for it to finish ...\n", job.Kind, id, info.Pid)
+
+	// Show the progress of the job while waiting for it.
+	var result Job
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call("Daemon.Wait", id, &result)
+	}()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var shown string
+wait:
+	for {
+		select {
+		case err = <-done:
+			break wait
+		case <-ticker.C:
+			var job Job
+			if client.Call("Daemon.Job", id, &job) == nil && job.Progress != shown {
+				shown = job.Progress
+				fmt.Printf("\r%s\033[K", shown)
+			}
+		}
+	}
+	if shown != "" {
+		fmt.Print("\r\033[K")
+	}
+	if err != nil {
+		return true
+	}
+	printJob(&result)
+	if result.State == JobFailed {
+		os.Exit(1)
+	}
+	return true
+}
+
+func printJob(job *Job) {
+	state := job.State
+	switch job.State {
+	case JobDone:
+		state = green(state)
+	case JobFailed:
+		state = red(state)
+	default:
+		state = yellow(state)
+	}
+	fmt.Printf("Job %d: %s %s, %s", job.ID, job.Builder, job.Kind, state)
+	if job.File != "" {
+		fmt.Printf(", file %s", job.File)
+	}
+	if !job.FinishedAt.IsZero() {
+		fmt.Printf(", took %v", job.FinishedAt.Sub(job.StartedAt))
+	}
+	if job.Result != "" {
+		fmt.Printf(", %s", job.Result)
+	}
+	if job.Error != "" {
+		fmt.Printf(", error %s", red(job.Error))
+	}
+	fmt.Println()
+}
+
+func putJob(ds *meta.Datastore, job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return ds.Put(meta.MakeKey(meta.DaemonJobPrefix, fmt.Sprint(job.ID)), b)
+}
+
+func getJobList(ds *meta.Datastore) ([]Job, error) {
+	result, err := ds.Query(query.Query{
+		Prefix: meta.DaemonJobPrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for entry := range result.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		var job Job
+		err = json.Unmarshal(entry.Value, &job)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
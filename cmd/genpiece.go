@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/meta"
+)
+
+const (
+	PiecePatternRandom = "random"
+	PiecePatternZeros  = "zeros"
+	PiecePatternSeeded = "seeded"
+	PiecePatternText   = "text"
+)
+
+var genPieceSize string
+var genPiecePattern string
+var genPieceSeed int64
+var genPieceDAG bool
+
+// addGenPieceFlags adds the flags of the generate-piece commands.
+func addGenPieceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&genPieceSize, "size", "", "The piece size, a fixed size like 1MiB, a uniform range like 1KiB-4MiB, normal:MEAN:STDDEV or exp:MEAN, defaults to the max bytes of a sector")
+	cmd.Flags().StringVar(&genPiecePattern, "pattern", PiecePatternRandom, "The piece content, random, zeros, seeded (PRNG by --seed) or text (compressible text by --seed)")
+	cmd.Flags().Int64Var(&genPieceSeed, "seed", 0, "The seed of piece sizes and seeded or text content, defaults to a printed time based seed")
+	cmd.Flags().BoolVar(&genPieceDAG, "dag", false, "Import generated pieces into the pieces DAG, so that they can be retrieved by get-piece")
+}
+
+// PieceSize chooses the sizes of generated pieces.
+type PieceSize struct {
+	// Kind is fixed, range, normal or exp.
+	Kind     string
+	Min, Max uint64
+	Mean     float64
+	StdDev   float64
+}
+
+// parsePieceSize parses the --size flag, where maxBytes is the max bytes of a
+// piece.
+func parsePieceSize(s string, maxBytes uint64) (*PieceSize, error) {
+	if s == "" {
+		return &PieceSize{Kind: "fixed", Min: maxBytes, Max: maxBytes}, nil
+	}
+
+	parts := strings.Split(s, ":")
+	switch {
+	case parts[0] == "normal" && len(parts) == 3:
+		mean, err := parseByteSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		stdDev, err := parseByteSize(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		return &PieceSize{Kind: "normal", Min: 1, Max: maxBytes, Mean: float64(mean), StdDev: float64(stdDev)}, nil
+	case parts[0] == "exp" && len(parts) == 2:
+		mean, err := parseByteSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return &PieceSize{Kind: "exp", Min: 1, Max: maxBytes, Mean: float64(mean)}, nil
+	case len(parts) != 1:
+		return nil, fmt.Errorf("invalid piece size %q", s)
+	}
+
+	size := &PieceSize{Kind: "fixed"}
+	var err error
+	if i := strings.Index(s, "-"); i >= 0 {
+		size.Kind = "range"
+		size.Min, err = parseByteSize(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		size.Max, err = parseByteSize(s[i+1:])
+	} else {
+		size.Min, err = parseByteSize(s)
+		size.Max = size.Min
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size.Min == 0 || size.Min > size.Max {
+		return nil, fmt.Errorf("invalid piece size %q", s)
+	}
+	if size.Max > maxBytes {
+		return nil, fmt.Errorf("piece size %q exceeds the max %d bytes of a sector", s, maxBytes)
+	}
+	return size, nil
+}
+
+// Next returns the size of the next piece.
+func (s *PieceSize) Next(rng *mathrand.Rand) uint64 {
+	var v float64
+	switch s.Kind {
+	case "range":
+		return s.Min + uint64(rng.Int63n(int64(s.Max-s.Min+1)))
+	case "normal":
+		v = rng.NormFloat64()*s.StdDev + s.Mean
+	case "exp":
+		v = rng.ExpFloat64() * s.Mean
+	default:
+		return s.Min
+	}
+	if v < float64(s.Min) {
+		return s.Min
+	}
+	if v > float64(s.Max) {
+		return s.Max
+	}
+	return uint64(v)
+}
+
+var byteSizeUnits = []struct {
+	suffix string
+	n      uint64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses bytes with an optional KiB, MiB or GiB unit.
+func parseByteSize(s string) (uint64, error) {
+	unit := uint64(1)
+	num := s
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			unit = u.n
+			num = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// PieceGenerator generates synthetic pieces. Sizes and the seeded and text
+// patterns are reproducible by the seed.
+type PieceGenerator struct {
+	Size    *PieceSize
+	Pattern string
+	Seed    int64
+	rng     *mathrand.Rand
+}
+
+// parsePiecePattern parses the --pattern flag.
+func parsePiecePattern(s string) (string, error) {
+	switch s {
+	case PiecePatternRandom, PiecePatternZeros, PiecePatternSeeded, PiecePatternText:
+		return s, nil
+	default:
+		return "", fmt.Errorf("invalid pattern %q, expect random, zeros, seeded or text", s)
+	}
+}
+
+// newPieceGenerator creates a generator by the generate-piece flags of cmd.
+func newPieceGenerator(cmd *cobra.Command, maxBytes uint64) (*PieceGenerator, error) {
+	pattern, err := parsePiecePattern(genPiecePattern)
+	if err != nil {
+		return nil, err
+	}
+	size, err := parsePieceSize(genPieceSize, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	seed := genPieceSeed
+	if !cmd.Flags().Changed("seed") {
+		seed = time.Now().UnixNano()
+		fmt.Printf("Seed: %d\n", seed)
+	}
+	return &PieceGenerator{
+		Size:    size,
+		Pattern: pattern,
+		Seed:    seed,
+		rng:     mathrand.New(mathrand.NewSource(seed)),
+	}, nil
+}
+
+// Next generates the data of the next piece.
+func (g *PieceGenerator) Next() ([]byte, error) {
+	data := make([]byte, g.Size.Next(g.rng))
+	switch g.Pattern {
+	case PiecePatternZeros:
+	case PiecePatternSeeded:
+		_, _ = g.rng.Read(data) // never fails
+	case PiecePatternText:
+		fillText(g.rng, data)
+	default:
+		if _, err := io.ReadFull(rand.Reader, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+var textWords = strings.Fields(`the of and to in is that for it as was with be by on not he this are or his
+from at which but have an they you were her she there been one all we their has would when if so no
+filecoin sector piece seal proof storage miner replica commitment deal`)
+
+// fillText fills data with words, which compress well like real text.
+func fillText(rng *mathrand.Rand, data []byte) {
+	for i := 0; i < len(data); {
+		word := textWords[rng.Intn(len(textWords))]
+		i += copy(data[i:], word)
+		if i < len(data) {
+			if rng.Intn(12) == 0 {
+				data[i] = '\n'
+			} else {
+				data[i] = ' '
+			}
+			i++
+		}
+	}
+}
+
+// generatedPieceCid returns the cid of the generated piece data. The data is
+// imported into the pieces DAG if dag is not nil, otherwise the cid is of the
+// raw data.
+func generatedPieceCid(dag *DAG, ds *meta.Datastore, data []byte) (cid.Cid, error) {
+	if dag == nil {
+		return merkledag.NewRawNode(data).Cid(), nil
+	}
+	return importPieceData(dag, ds, bytes.NewReader(data), uint64(len(data)), "", ImportOptions{})
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	mathrand "math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		n    uint64
+		fail bool
+	}{
+		{s: "0", n: 0},
+		{s: "127", n: 127},
+		{s: "127B", n: 127},
+		{s: "3KiB", n: 3 << 10},
+		{s: "5MiB", n: 5 << 20},
+		{s: "1GiB", n: 1 << 30},
+		{s: "", fail: true},
+		{s: "KiB", fail: true},
+		{s: "1.5MiB", fail: true},
+		{s: "-1", fail: true},
+		{s: "1kib", fail: true},
+		{s: "1 KiB", fail: true},
+	} {
+		n, err := parseByteSize(c.s)
+		if c.fail {
+			if err == nil {
+				t.Fatalf("%q: parsed %d, expected an error", c.s, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", c.s, err)
+		}
+		if n != c.n {
+			t.Fatalf("%q: parsed %d, expected %d", c.s, n, c.n)
+		}
+	}
+}
+
+func TestParsePieceSize(t *testing.T) {
+	const maxBytes = 1 << 20
+	for _, c := range []struct {
+		s    string
+		size *PieceSize
+	}{
+		{"", &PieceSize{Kind: "fixed", Min: maxBytes, Max: maxBytes}},
+		{"1024", &PieceSize{Kind: "fixed", Min: 1024, Max: 1024}},
+		{"1MiB", &PieceSize{Kind: "fixed", Min: maxBytes, Max: maxBytes}},
+		{"1KiB-4KiB", &PieceSize{Kind: "range", Min: 1 << 10, Max: 4 << 10}},
+		{"127-127", &PieceSize{Kind: "range", Min: 127, Max: 127}},
+		{"normal:1KiB:256B", &PieceSize{Kind: "normal", Min: 1, Max: maxBytes, Mean: 1024, StdDev: 256}},
+		{"exp:512", &PieceSize{Kind: "exp", Min: 1, Max: maxBytes, Mean: 512}},
+		// Invalid sizes.
+		{"0", nil},
+		{"4KiB-1KiB", nil},
+		{"0-1KiB", nil},
+		{"1KiB-", nil},
+		{"-1KiB", nil},
+		{"2MiB", nil},
+		{"1KiB-2MiB", nil},
+		{"normal:1KiB", nil},
+		{"normal:1KiB:x", nil},
+		{"exp:1:2", nil},
+		{"uniform:1", nil},
+		{"1XB", nil},
+	} {
+		size, err := parsePieceSize(c.s, maxBytes)
+		if c.size == nil {
+			if err == nil {
+				t.Fatalf("%q: parsed %+v, expected an error", c.s, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", c.s, err)
+		}
+		if !reflect.DeepEqual(size, c.size) {
+			t.Fatalf("%q: parsed %+v, expected %+v", c.s, size, c.size)
+		}
+	}
+}
+
+func TestPieceSizeNext(t *testing.T) {
+	for _, s := range []string{"1000", "1KiB-4KiB", "normal:2KiB:1KiB", "exp:1KiB"} {
+		size, err := parsePieceSize(s, 4<<10)
+		if err != nil {
+			t.Fatalf("%q: %s", s, err)
+		}
+		rng := mathrand.New(mathrand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			n := size.Next(rng)
+			if n < size.Min || n > size.Max {
+				t.Fatalf("%q: next size %d out of [%d, %d]", s, n, size.Min, size.Max)
+			}
+		}
+	}
+}
+
+func TestParsePiecePattern(t *testing.T) {
+	for _, p := range []string{PiecePatternRandom, PiecePatternZeros, PiecePatternSeeded, PiecePatternText} {
+		if _, err := parsePiecePattern(p); err != nil {
+			t.Fatalf("%q: %s", p, err)
+		}
+	}
+	for _, p := range []string{"", "Random", "ones"} {
+		if _, err := parsePiecePattern(p); err == nil {
+			t.Fatalf("%q: parsed, expected an error", p)
+		}
+	}
+}
+
+func TestPieceGeneratorSeed(t *testing.T) {
+	generate := func(pattern string, seed int64) []byte {
+		size, err := parsePieceSize("1KiB-4KiB", 4<<10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := &PieceGenerator{Size: size, Pattern: pattern, Seed: seed, rng: mathrand.New(mathrand.NewSource(seed))}
+		data, err := g.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, pattern := range []string{PiecePatternSeeded, PiecePatternText} {
+		if !bytes.Equal(generate(pattern, 7), generate(pattern, 7)) {
+			t.Fatalf("%s pieces of the same seed differ", pattern)
+		}
+		if bytes.Equal(generate(pattern, 7), generate(pattern, 8)) {
+			t.Fatalf("%s pieces of different seeds are equal", pattern)
+		}
+	}
+	if data := generate(PiecePatternZeros, 7); !bytes.Equal(data, make([]byte, len(data))) {
+		t.Fatal("zeros piece is not all zeros")
+	}
+	for _, b := range generate(PiecePatternText, 7) {
+		if b != ' ' && b != '\n' && (b < 'a' || b > 'z') {
+			t.Fatalf("text piece holds byte %q", b)
+		}
+	}
+}
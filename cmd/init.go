@@ -2,14 +2,11 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 
-	"github.com/ipfs/go-datastore"
-	badgerds "github.com/ipfs/go-ds-badger"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
+	"github.com/filcloud/filutil/meta"
 	"github.com/filecoin-project/go-filecoin/repo"
 )
 
@@ -22,63 +19,44 @@ var InitCmd = &cobra.Command{
 	Short: "Initialize a filutil directory",
 	Long:  "",
 	Run: func(cmd *cobra.Command, args []string) {
-		var err error
-		defer func() {
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
-		}()
-
-		filutilDir := getFilutilDir()
-
-		err = repo.EnsureWritableDirectory(filutilDir)
-		if err != nil {
-			return
-		}
-		empty, err := repo.IsEmptyDir(filutilDir)
-		if err != nil {
-			err = errors.Wrapf(err, "failed to list filutil directory %s", filutilDir)
-			return
-		}
-		if !empty {
-			err = fmt.Errorf("refusing to initialize filutil in non-empty directory %s", filutilDir)
-			return
-		}
-
-		dag := openSectorBuilderPiecesDAG()
-		defer dag.Close()
-		datastore := openMetaDatastore()
-		defer datastore.Close()
+		exitOnError(initFilutilDir())
 	},
 }
 
-type Datastore struct {
-	repo.Datastore
-}
+// initFilutilDir initializes the empty filutil directory with the configured
+// sector size.
+func initFilutilDir() error {
+	filutilDir := getFilutilDir()
 
-func (d *Datastore) Close() {
-	err := d.Datastore.Close()
+	err := repo.EnsureWritableDirectory(filutilDir)
 	if err != nil {
-		panic(err)
+		return err
 	}
-}
-
-func openMetaDatastore() *Datastore {
-	options := &badgerds.DefaultOptions
-	d, err := badgerds.NewDatastore(filepath.Join(getFilutilDir(), "meta"), options)
+	empty, err := repo.IsEmptyDir(filutilDir)
 	if err != nil {
-		panic(err)
+		return errors.Wrapf(err, "failed to list filutil directory %s", filutilDir)
+	}
+	if !empty {
+		return fmt.Errorf("refusing to initialize filutil in non-empty directory %s", filutilDir)
 	}
-	return &Datastore{d}
-}
-
-const (
-	metaSectorBuilderPiecePrefix                = "/piece"
-	metaSectorBuilderLastUsedSectorIDPrefix     = "/last-used-sector-id"
-	metaSectorBuilderSealedSectorMetadataPrefix = "/sealed-sector-metadata"
-)
 
-func makeKey(parts ...string) datastore.Key {
-	return datastore.KeyWithNamespaces(parts)
+	dag, err := openSectorBuilderPiecesDAG()
+	if err != nil {
+		return err
+	}
+	defer dag.Close()
+	datastore, err := meta.OpenUnmigrated(getFilutilDir())
+	if err != nil {
+		return err
+	}
+	defer datastore.Close()
+	err = meta.PutSchemaVersion(datastore, meta.CurrentSchemaVersion)
+	if err != nil {
+		return err
+	}
+	err = meta.PutSectorSize(datastore, sectorSize.Uint64())
+	if err != nil {
+		return err
+	}
+	return writeDefaultConfig()
 }
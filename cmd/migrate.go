@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/meta"
+)
+
+var migrateDryRun bool
+
+func init() {
+	rootCmd.AddCommand(RepoCmd)
+
+	RepoCmd.AddCommand(RepoMigrateCmd)
+
+	RepoMigrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Show the pending migrations and the changes they would make without applying them")
+}
+
+var RepoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Commands for the filutil directory",
+}
+
+var RepoMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the meta datastore to the schema version of this filutil",
+	Long:  "Migrate the meta datastore to the schema version of this filutil. Opening the meta datastore migrates it too, but migrate --dry-run shows the pending migrations without applying them.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		ds, err := meta.OpenUnmigrated(getFilutilDir())
+		if err != nil {
+			return
+		}
+		defer ds.Close()
+
+		version, pending, err := meta.PendingMigrations(ds)
+		if err != nil {
+			return
+		}
+		fmt.Printf("Schema version: %d, current: %d\n", version, meta.CurrentSchemaVersion)
+		if len(pending) == 0 {
+			fmt.Println("Up to date")
+			return
+		}
+		err = meta.Migrate(ds, migrateDryRun)
+	},
+}
@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/meta"
+)
+
+var pieceRecursive bool
+var piecePerFile bool
+
+// addImportFlags adds the flags of importing directories, chunking and DAG
+// layout to the add-piece commands.
+func addImportFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&pieceRecursive, "recursive", "r", false, "Import a directory tree as one UnixFS directory piece")
+	cmd.Flags().BoolVar(&piecePerFile, "per-file", false, "With --recursive, import each file of the directory tree as its own piece")
+	addImportOptionFlags(cmd)
+}
+
+// unixfsFolderData is the protobuf encoded UnixFS data of a directory node,
+// the same as unixfs.FolderPBData.
+var unixfsFolderData = []byte{0x08, 0x01}
+
+// pieceTarModTime is the modification time of all tar entries, so that the
+// piece data of a directory is determined by its DAG.
+var pieceTarModTime = time.Unix(0, 0)
+
+// ImportedPiece is a piece imported into the pieces DAG.
+type ImportedPiece struct {
+	Cid  cid.Cid
+	Size uint64
+	// Path is the file or directory the piece is imported from.
+	Path string
+	// Dir tells whether the piece is a directory, whose piece data is a tar
+	// archive of the directory tree.
+	Dir bool
+}
+
+// importPieces imports the file, or the directory tree if recursive, into the
+// pieces DAG as one piece, or one piece per file if perFile.
+func importPieces(dag *DAG, ds *meta.Datastore, filename string, recursive, perFile bool, opts ImportOptions) ([]ImportedPiece, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		c, size, err := importPiece(dag, ds, filename, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []ImportedPiece{{Cid: c, Size: size, Path: filename}}, nil
+	}
+	if !recursive {
+		return nil, fmt.Errorf("%s is a directory, import it by --recursive", filename)
+	}
+
+	if perFile {
+		var pieces []ImportedPiece
+		err = filepath.Walk(filename, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				if !info.IsDir() {
+					fmt.Printf("%s %s is not a regular file, skipped\n", yellow("Warning:"), p)
+				}
+				return nil
+			}
+			c, size, err := importPiece(dag, ds, p, opts)
+			if err != nil {
+				return errors.Wrapf(err, "failed to import %s", p)
+			}
+			pieces = append(pieces, ImportedPiece{Cid: c, Size: size, Path: p})
+			return nil
+		})
+		return pieces, err
+	}
+
+	nd, err := dag.importDir(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	commP, size, err := dag.PieceCommitment(nd.Cid())
+	if err != nil {
+		return nil, err
+	}
+	err = recordPiece(ds, nd.Cid(), size, commP[:], filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Imported directory piece %s, CommP %x\n", nd.Cid(), commP)
+	return []ImportedPiece{{Cid: nd.Cid(), Size: size, Path: filename, Dir: true}}, nil
+}
+
+// importDir imports the directory tree as a UnixFS directory DAG. Files other
+// than regular files and directories are skipped.
+func (d *DAG) importDir(dir string, opts ImportOptions) (format.Node, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	nd := merkledag.NodeWithData(unixfsFolderData)
+	nd.SetCidBuilder(opts.cidBuilder())
+	for _, info := range infos {
+		p := filepath.Join(dir, info.Name())
+		var child format.Node
+		switch {
+		case info.IsDir():
+			child, err = d.importDir(p, opts)
+		case info.Mode().IsRegular():
+			child, err = d.importFile(p, uint64(info.Size()), opts)
+		default:
+			fmt.Printf("%s %s is not a regular file, skipped\n", yellow("Warning:"), p)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to import %s", p)
+		}
+		err = nd.AddNodeLink(info.Name(), child)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = d.dagService.Add(context.Background(), nd)
+	if err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (d *DAG) importFile(filename string, size uint64, opts ImportOptions) (format.Node, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	progress := newProgress(fmt.Sprintf("Importing %s", filename), size)
+	nd, err := d.importData(progress.Reader(file), opts)
+	if err != nil {
+		return nil, err
+	}
+	progress.Finish()
+	return nd, nil
+}
+
+// isPieceDir tells whether the node is a UnixFS directory.
+func isPieceDir(nd format.Node) bool {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	return ok && bytes.Equal(pn.Data(), unixfsFolderData)
+}
+
+// OpenPiece opens the piece data of c. The piece data of a directory is a tar
+// archive of the directory tree, staged in a temp file.
+func (d *DAG) OpenPiece(c cid.Cid) (r io.ReadCloser, size uint64, err error) {
+	nd, err := d.dagService.Get(context.Background(), c)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !isPieceDir(nd) {
+		dr, err := d.dag.Cat(context.Background(), c)
+		if err != nil {
+			return nil, 0, err
+		}
+		return ioutil.NopCloser(dr), dr.Size(), nil
+	}
+
+	file, err := ioutil.TempFile("", "filutil-piece-")
+	if err != nil {
+		return nil, 0, err
+	}
+	tf := &tempFile{file}
+	defer func() {
+		if err != nil {
+			_ = tf.Close()
+		}
+	}()
+	err = d.writePieceTar(file, nd)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tf, uint64(n), nil
+}
+
+// PieceSize returns the size of the piece data of c.
+func (d *DAG) PieceSize(c cid.Cid) (uint64, error) {
+	nd, err := d.dagService.Get(context.Background(), c)
+	if err != nil {
+		return 0, err
+	}
+	if !isPieceDir(nd) {
+		dr, err := d.dag.Cat(context.Background(), c)
+		if err != nil {
+			return 0, err
+		}
+		return dr.Size(), nil
+	}
+	w := &countingWriter{}
+	err = d.writePieceTar(w, nd)
+	return w.n, err
+}
+
+// tempFile is a temp file removed on close.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if err1 := os.Remove(f.Name()); err == nil {
+		err = err1
+	}
+	return err
+}
+
+type countingWriter struct {
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += uint64(len(p))
+	return len(p), nil
+}
+
+// writePieceTar writes the directory tree of nd as a tar archive.
+func (d *DAG) writePieceTar(w io.Writer, nd format.Node) error {
+	tw := tar.NewWriter(w)
+	err := d.walkPieceDir(nd, "", func(name string, nd format.Node) error {
+		if isPieceDir(nd) {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     0755,
+				ModTime:  pieceTarModTime,
+			})
+		}
+		r, err := d.dag.Cat(context.Background(), nd.Cid())
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(r.Size()),
+			ModTime:  pieceTarModTime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// walkPieceDir visits the entries of the directory nd in link order, with
+// their slash separated paths relative to nd.
+func (d *DAG) walkPieceDir(nd format.Node, dir string, visit func(name string, nd format.Node) error) error {
+	for _, link := range nd.Links() {
+		if link.Name == "" || link.Name == "." || link.Name == ".." || strings.ContainsAny(link.Name, `/\`) {
+			return fmt.Errorf("invalid directory entry name %q", link.Name)
+		}
+		child, err := d.dagService.Get(context.Background(), link.Cid)
+		if err != nil {
+			return err
+		}
+		name := path.Join(dir, link.Name)
+		err = visit(name, child)
+		if err != nil {
+			return err
+		}
+		if isPieceDir(child) {
+			err = d.walkPieceDir(child, name, visit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// savePieceDir reconstructs the directory piece c as the directory dir.
+func (d *DAG) savePieceDir(c cid.Cid, dir string) error {
+	nd, err := d.dagService.Get(context.Background(), c)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return d.walkPieceDir(nd, "", func(name string, nd format.Node) error {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if isPieceDir(nd) {
+			return os.MkdirAll(p, 0755)
+		}
+		r, err := d.dag.Cat(context.Background(), nd.Cid())
+		if err != nil {
+			return err
+		}
+		return saveReader(r, r.Size(), p)
+	})
+}
+
+// saveReader saves size bytes read from r into filename.
+func saveReader(r io.Reader, size uint64, filename string) (err error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && uint64(n) < size {
+		err = io.ErrShortWrite
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
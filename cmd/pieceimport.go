@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	h "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipfs/go-unixfs/importer/trickle"
+	"github.com/spf13/cobra"
+)
+
+const (
+	LayoutBalanced = "balanced"
+	LayoutTrickle  = "trickle"
+)
+
+// ImportOptions configures how data is chunked and laid out into the pieces
+// DAG. The zero value is the defaults of dag.ImportData.
+type ImportOptions struct {
+	// Chunker is size-N or rabin-min-avg-max.
+	Chunker    string `json:",omitempty"`
+	Layout     string `json:",omitempty"`
+	RawLeaves  bool   `json:",omitempty"`
+	CidVersion int    `json:",omitempty"`
+}
+
+var pieceImportOptions ImportOptions
+
+// addImportOptionFlags adds the flags of chunking and DAG layout to the
+// add-piece commands.
+func addImportOptionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pieceImportOptions.Chunker, "chunker", defaultChunker, "The chunker, size-N for fixed N bytes chunks or rabin-min-avg-max for Rabin fingerprint chunks")
+	cmd.Flags().StringVar(&pieceImportOptions.Layout, "layout", LayoutBalanced, "The DAG layout, balanced or trickle")
+	cmd.Flags().BoolVar(&pieceImportOptions.RawLeaves, "raw-leaves", false, "Store file data in raw leaf nodes instead of UnixFS leaf nodes")
+	cmd.Flags().IntVar(&pieceImportOptions.CidVersion, "cid-version", 0, "The cid version of the DAG nodes, 0 or 1")
+}
+
+// withDefaults returns the options with the defaults filled in.
+func (o ImportOptions) withDefaults() ImportOptions {
+	if o.Chunker == "" {
+		o.Chunker = defaultChunker
+	}
+	if o.Layout == "" {
+		o.Layout = LayoutBalanced
+	}
+	return o
+}
+
+func (o ImportOptions) validate() error {
+	switch o.Layout {
+	case "", LayoutBalanced, LayoutTrickle:
+	default:
+		return fmt.Errorf("invalid layout %q, expect balanced or trickle", o.Layout)
+	}
+	if o.CidVersion != 0 && o.CidVersion != 1 {
+		return fmt.Errorf("invalid cid version %d, expect 0 or 1", o.CidVersion)
+	}
+	if o.Chunker != "" {
+		if _, err := chunk.FromString(strings.NewReader(""), o.Chunker); err != nil {
+			return fmt.Errorf("invalid chunker %q: %s", o.Chunker, err)
+		}
+	}
+	return nil
+}
+
+func (o ImportOptions) cidBuilder() cid.Builder {
+	if o.CidVersion == 1 {
+		return merkledag.V1CidPrefix()
+	}
+	return merkledag.V0CidPrefix()
+}
+
+// importData imports the data read from r into the pieces DAG by the options.
+func (d *DAG) importData(r io.Reader, opts ImportOptions) (format.Node, error) {
+	opts = opts.withDefaults()
+	spl, err := chunk.FromString(r, opts.Chunker)
+	if err != nil {
+		return nil, err
+	}
+	params := &h.DagBuilderParams{
+		Maxlinks:   h.DefaultLinksPerBlock,
+		RawLeaves:  opts.RawLeaves,
+		CidBuilder: opts.cidBuilder(),
+		Dagserv:    d.dagService,
+	}
+	db := params.New(spl)
+	if opts.Layout == LayoutTrickle {
+		return trickle.Layout(db)
+	}
+	return balanced.Layout(db)
+}
@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/meta"
+)
+
+const (
+	PieceSortCid  = "cid"
+	PieceSortSize = "size"
+	PieceSortTime = "time"
+	PieceSortName = "name"
+)
+
+// defaultChunker is the chunker the pieces DAG imports data with.
+const defaultChunker = "size-262144"
+
+var lsPiecesFilters []string
+var lsPiecesSort string
+
+func init() {
+	SectorBuilderCmd.AddCommand(SectorBuilderLabelPieceCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderLabelPieceCmd)
+
+	addLsPiecesFlags(SectorBuilderLsPiecesCmd)
+	addLsPiecesFlags(SimpleSectorBuilderLsPiecesCmd)
+}
+
+func addLsPiecesFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&lsPiecesFilters, "filter", nil, "Only list pieces with the label, key=value or key for any value, all filters must match")
+	cmd.Flags().StringVar(&lsPiecesSort, "sort", PieceSortCid, "Sort pieces by cid, size, time (import time) or name (filename)")
+}
+
+// PieceRecord is the metadata of a piece in the pieces DAG.
+type PieceRecord struct {
+	Cid string
+	// Filename is the absolute path of the file or directory the piece was
+	// imported from.
+	Filename   string `json:",omitempty"`
+	Size       uint64
+	ImportedAt time.Time
+	// ImportOptions are the chunker and DAG layout the piece was imported
+	// with.
+	ImportOptions
+	CommP string `json:",omitempty"`
+	// SectorID is the staged sector the piece was last added into.
+	SectorID *uint64           `json:",omitempty"`
+	Labels   map[string]string `json:",omitempty"`
+}
+
+// decodePieceRecord decodes the record of piece c. Pieces imported before
+// records were introduced store only the CommP bytes, or nothing.
+func decodePieceRecord(c string, v []byte) (*PieceRecord, error) {
+	if len(v) > 0 && v[0] == '{' {
+		var r PieceRecord
+		if err := json.Unmarshal(v, &r); err == nil {
+			return &r, nil
+		}
+	}
+	r := &PieceRecord{Cid: c}
+	switch len(v) {
+	case 0:
+	case 32:
+		r.CommP = hex.EncodeToString(v)
+	default:
+		return nil, fmt.Errorf("invalid record of piece %s", c)
+	}
+	return r, nil
+}
+
+func getPieceRecord(ds *meta.Datastore, c cid.Cid) (*PieceRecord, error) {
+	v, err := ds.Get(meta.MakeKey(meta.PiecePrefix, c.String()))
+	if err == datastore.ErrNotFound {
+		return nil, fmt.Errorf("piece %s not found", c)
+	} else if err != nil {
+		return nil, err
+	}
+	return decodePieceRecord(c.String(), v)
+}
+
+func putPieceRecord(ds *meta.Datastore, r *PieceRecord) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ds.Put(meta.MakeKey(meta.PiecePrefix, r.Cid), v)
+}
+
+func getPieceRecordList(ds *meta.Datastore) ([]*PieceRecord, error) {
+	result, err := ds.Query(query.Query{
+		Prefix: meta.PiecePrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var records []*PieceRecord
+	for entry := range result.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		r, err := decodePieceRecord(strings.TrimPrefix(entry.Key, meta.PiecePrefix+"/"), entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// recordPiece records the imported piece c with its CommP and import options,
+// and the path it is imported from if path is not empty. Only the import
+// fields of a piece imported again are overwritten, its sector and labels are
+// kept.
+func recordPiece(ds *meta.Datastore, c cid.Cid, size uint64, commP []byte, path string, opts ImportOptions) error {
+	r := &PieceRecord{Cid: c.String()}
+	has, err := ds.Has(meta.MakeKey(meta.PiecePrefix, c.String()))
+	if err != nil {
+		return err
+	}
+	if has {
+		r, err = getPieceRecord(ds, c)
+		if err != nil {
+			return err
+		}
+	}
+	r.Filename = ""
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		r.Filename = abs
+	}
+	r.Size = size
+	r.ImportedAt = time.Now()
+	r.ImportOptions = opts.withDefaults()
+	r.CommP = hex.EncodeToString(commP)
+	return putPieceRecord(ds, r)
+}
+
+// setPieceSector records the sector the piece c was added into. Pieces not in
+// the pieces DAG have no record, and are ignored.
+func setPieceSector(ds *meta.Datastore, c cid.Cid, sectorID uint64) error {
+	has, err := ds.Has(meta.MakeKey(meta.PiecePrefix, c.String()))
+	if err != nil || !has {
+		return err
+	}
+	r, err := getPieceRecord(ds, c)
+	if err != nil {
+		return err
+	}
+	r.SectorID = &sectorID
+	return putPieceRecord(ds, r)
+}
+
+// PieceFilter matches the label Key, and its Value unless AnyValue.
+type PieceFilter struct {
+	Key      string
+	Value    string
+	AnyValue bool
+}
+
+func parsePieceFilters(filters []string) ([]PieceFilter, error) {
+	var parsed []PieceFilter
+	for _, f := range filters {
+		i := strings.Index(f, "=")
+		if i < 0 {
+			parsed = append(parsed, PieceFilter{Key: f, AnyValue: true})
+		} else {
+			parsed = append(parsed, PieceFilter{Key: f[:i], Value: f[i+1:]})
+		}
+		if parsed[len(parsed)-1].Key == "" {
+			return nil, fmt.Errorf("invalid filter %q, expect key=value or key", f)
+		}
+	}
+	return parsed, nil
+}
+
+func (f PieceFilter) Match(r *PieceRecord) bool {
+	v, ok := r.Labels[f.Key]
+	return ok && (f.AnyValue || v == f.Value)
+}
+
+// filterPieceRecords returns the records matching all filters, sorted by
+// sortBy.
+func filterPieceRecords(records []*PieceRecord, filters []PieceFilter, sortBy string) ([]*PieceRecord, error) {
+	var less func(a, b *PieceRecord) bool
+	switch sortBy {
+	case PieceSortCid:
+		less = func(a, b *PieceRecord) bool { return a.Cid < b.Cid }
+	case PieceSortSize:
+		less = func(a, b *PieceRecord) bool { return a.Size < b.Size }
+	case PieceSortTime:
+		less = func(a, b *PieceRecord) bool { return a.ImportedAt.Before(b.ImportedAt) }
+	case PieceSortName:
+		less = func(a, b *PieceRecord) bool { return a.Filename < b.Filename }
+	default:
+		return nil, fmt.Errorf("invalid sort %q, expect cid, size, time or name", sortBy)
+	}
+
+	var matched []*PieceRecord
+	for _, r := range records {
+		ok := true
+		for _, f := range filters {
+			ok = ok && f.Match(r)
+		}
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return less(matched[i], matched[j]) })
+	return matched, nil
+}
+
+func formatPieceRecord(r *PieceRecord) string {
+	var parts []string
+	if r.Filename != "" {
+		parts = append(parts, "file: "+r.Filename)
+	}
+	if !r.ImportedAt.IsZero() {
+		parts = append(parts, "imported at: "+r.ImportedAt.Format("2006-01-02 15:04:05"))
+	}
+	if r.Chunker != "" {
+		parts = append(parts, "chunker: "+r.Chunker)
+	}
+	if r.Layout != "" {
+		parts = append(parts, "layout: "+r.Layout)
+	}
+	if r.RawLeaves {
+		parts = append(parts, "raw leaves")
+	}
+	if r.CidVersion != 0 {
+		parts = append(parts, fmt.Sprintf("cid version: %d", r.CidVersion))
+	}
+	if r.SectorID != nil {
+		parts = append(parts, fmt.Sprintf("sector: %d", *r.SectorID))
+	}
+	if len(r.Labels) > 0 {
+		var labels []string
+		for k, v := range r.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		parts = append(parts, "labels: "+yellow(strings.Join(labels, ",")))
+	}
+	return strings.Join(parts, ", ")
+}
+
+var SectorBuilderLabelPieceCmd = &cobra.Command{
+	Use:   "label-piece <cid> <key=value...>",
+	Short: "Set labels of a piece",
+	Long:  "Set labels of a piece, which ls-pieces --filter selects pieces by. A label with an empty value, like key=, is removed.",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		c, err := cid.Parse(args[0])
+		if err != nil {
+			return
+		}
+
+		ds, err := meta.Open(getFilutilDir())
+		if err != nil {
+			return
+		}
+		defer ds.Close()
+
+		r, err := getPieceRecord(ds, c)
+		if err != nil {
+			return
+		}
+		for _, label := range args[1:] {
+			i := strings.Index(label, "=")
+			if i <= 0 {
+				err = fmt.Errorf("invalid label %q, expect key=value", label)
+				return
+			}
+			key, value := label[:i], label[i+1:]
+			if value == "" {
+				delete(r.Labels, key)
+				continue
+			}
+			if r.Labels == nil {
+				r.Labels = map[string]string{}
+			}
+			r.Labels[key] = value
+		}
+		err = putPieceRecord(ds, r)
+		if err != nil {
+			return
+		}
+		fmt.Printf("Piece %s: %s\n", blue(r.Cid), formatPieceRecord(r))
+	},
+}
+
+var SimpleSectorBuilderLabelPieceCmd = aliasCommand(SectorBuilderLabelPieceCmd)
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+const (
+	PackFirstFit   = "first-fit"
+	PackBestFit    = "best-fit"
+	PackSizeSorted = "size-sorted"
+)
+
+var planStrategy string
+var planApply bool
+
+func init() {
+	SectorBuilderCmd.AddCommand(SectorBuilderPlanCmd)
+
+	SectorBuilderPlanCmd.Flags().StringVar(&planStrategy, "strategy", PackFirstFit, "The packing strategy, first-fit, best-fit or size-sorted")
+	SectorBuilderPlanCmd.Flags().BoolVar(&planApply, "apply", false, "Add the pieces in the planned order")
+	SectorBuilderPlanCmd.Flags().BoolVar(&noSeal, "no-seal", false, "Do not seal staged sectors after applying, but full sectors are still sealed by the sector builder")
+}
+
+// PlannedPiece is a file to be added as a piece.
+type PlannedPiece struct {
+	File string
+	Size uint64
+	// Offset is the offset of the piece in the sector after left alignment.
+	Offset uint64
+}
+
+// PlannedSector is a sector filled by a plan. Sectors are numbered from 1 in
+// the plan, not by the sector IDs the sector builder will assign.
+type PlannedSector struct {
+	Number int
+	Pieces []*PlannedPiece
+	// Written is the bytes the pieces take in the sector, including
+	// alignment padding.
+	Written uint64
+	// Filled is the bytes of the pieces.
+	Filled uint64
+}
+
+// end returns the bytes the pieces would take in the sector after adding a
+// piece of size bytes, with its alignment padding.
+func (s *PlannedSector) end(size uint64) uint64 {
+	left, right := pieceAlignment(s.Written, size)
+	return s.Written + left + size + right
+}
+
+// fits tells whether a piece of size bytes still fits into the sector, with
+// its alignment padding.
+func (s *PlannedSector) fits(size, maxBytes uint64) bool {
+	return s.end(size) <= maxBytes
+}
+
+func (s *PlannedSector) add(p *PlannedPiece) {
+	left, right := pieceAlignment(s.Written, p.Size)
+	p.Offset = s.Written + left
+	s.Written = p.Offset + p.Size + right
+	s.Filled += p.Size
+	s.Pieces = append(s.Pieces, p)
+}
+
+// planSectors packs the pieces into sectors of maxBytes piece bytes by the
+// strategy:
+//
+//	first-fit: each piece in the given order goes into the first sector it
+//	  fits in.
+//	best-fit: each piece in the given order goes into the sector it leaves
+//	  the least bytes free in.
+//	size-sorted: first-fit with the pieces sorted from the largest to the
+//	  smallest.
+func planSectors(pieces []*PlannedPiece, maxBytes uint64, strategy string) ([]*PlannedSector, error) {
+	switch strategy {
+	case PackFirstFit, PackBestFit:
+	case PackSizeSorted:
+		pieces = append([]*PlannedPiece(nil), pieces...)
+		sort.SliceStable(pieces, func(i, j int) bool { return pieces[i].Size > pieces[j].Size })
+	default:
+		return nil, fmt.Errorf("invalid strategy %q, expect first-fit, best-fit or size-sorted", strategy)
+	}
+
+	var sectors []*PlannedSector
+	for _, p := range pieces {
+		if p.Size == 0 {
+			return nil, fmt.Errorf("piece %s is empty", p.File)
+		}
+		var dest *PlannedSector
+		for _, s := range sectors {
+			if !s.fits(p.Size, maxBytes) {
+				continue
+			}
+			if strategy != PackBestFit {
+				dest = s
+				break
+			}
+			// The free bytes left after placing the piece count its
+			// alignment padding, which differs between sectors.
+			if dest == nil || maxBytes-s.end(p.Size) < maxBytes-dest.end(p.Size) {
+				dest = s
+			}
+		}
+		if dest == nil {
+			dest = &PlannedSector{Number: len(sectors) + 1}
+			if !dest.fits(p.Size, maxBytes) {
+				return nil, fmt.Errorf("piece %s of %d bytes exceeds the max %d bytes of a sector", p.File, p.Size, maxBytes)
+			}
+			sectors = append(sectors, dest)
+		}
+		dest.add(p)
+	}
+	return sectors, nil
+}
+
+func printSectorPlan(sectors []*PlannedSector, maxBytes uint64) {
+	var filled uint64
+	for _, s := range sectors {
+		fmt.Printf("Sector %d: %d pieces\n", s.Number, len(s.Pieces))
+		for _, p := range s.Pieces {
+			fmt.Printf("  %s, offset %d, size %d\n", cyan(p.File), p.Offset, p.Size)
+		}
+		fmt.Printf("  Fill: %s of %s pieces (%.1f%%), %s alignment padding, %s wasted\n",
+			backend.FormatBytes(s.Filled), backend.FormatBytes(maxBytes), 100*float64(s.Filled)/float64(maxBytes),
+			backend.FormatBytes(s.Written-s.Filled), backend.FormatBytes(maxBytes-s.Filled))
+		filled += s.Filled
+	}
+	if len(sectors) > 0 {
+		total := maxBytes * uint64(len(sectors))
+		fmt.Printf("Total: %d sectors, %s of %s pieces (%.1f%%), %s wasted\n",
+			len(sectors), backend.FormatBytes(filled), backend.FormatBytes(total), 100*float64(filled)/float64(total),
+			backend.FormatBytes(total-filled))
+	}
+}
+
+var SectorBuilderPlanCmd = &cobra.Command{
+	Use:   "plan <files...>",
+	Short: "Plan packing files as pieces into sectors",
+	Long:  "Plan packing files as pieces into sectors by first-fit, best-fit or size-sorted, respecting the piece alignment of the sector builder, and show the utilization and wasted bytes of each sector. With --apply, the pieces are added sector by sector in the planned order. The plan assumes empty sectors, and the sector builder places pieces by itself, so pieces landing in other sectors than planned are reported.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		var pieces []*PlannedPiece
+		for _, file := range args {
+			var stat os.FileInfo
+			stat, err = os.Stat(file)
+			if err != nil {
+				return
+			}
+			if !stat.Mode().IsRegular() {
+				err = fmt.Errorf("%s is not a regular file", file)
+				return
+			}
+			pieces = append(pieces, &PlannedPiece{File: file, Size: uint64(stat.Size())})
+		}
+
+		maxBytes := go_sectorbuilder.GetMaxUserBytesPerStagedSector(sectorSize.Uint64())
+		sectors, err := planSectors(pieces, maxBytes, planStrategy)
+		if err != nil {
+			return
+		}
+		printSectorPlan(sectors, maxBytes)
+
+		if planApply {
+			err = applySectorPlan(sectors)
+		}
+	},
+}
+
+// applySectorPlan adds the pieces of the planned sectors in order, and reports
+// the pieces the sector builder put together with pieces of other planned
+// sectors.
+func applySectorPlan(sectors []*PlannedSector) error {
+	dag, err := openSectorBuilderPiecesDAG()
+	if err != nil {
+		return err
+	}
+	defer dag.Close()
+
+	_, b, err := openBackend(SectorBuilderCmd.Use, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+	ds := metaStoreOf(b)
+
+	start := time.Now()
+	var total uint64
+	planned := map[uint64]int{} // sector ID to planned sector
+	actual := map[int]uint64{}  // planned sector to sector ID
+	var mismatches int
+	for _, s := range sectors {
+		for _, p := range s.Pieces {
+			c, size, err := importPiece(dag, ds, p.File, ImportOptions{})
+			if err != nil {
+				return errors.Wrapf(err, "failed to import %s", p.File)
+			}
+			sectorID, err := addSectorsPiece(b, dag, c)
+			if err == nil {
+				err = setPieceSector(ds, c, sectorID)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to add %s", p.File)
+			}
+			total += size
+
+			if n, ok := planned[sectorID]; ok && n != s.Number {
+				fmt.Printf("%s piece %s of planned sector %d went into sector %d with planned sector %d\n",
+					yellow("Warning:"), p.File, s.Number, sectorID, n)
+				mismatches++
+			} else if id, ok := actual[s.Number]; ok && id != sectorID {
+				fmt.Printf("%s piece %s of planned sector %d went into sector %d instead of sector %d\n",
+					yellow("Warning:"), p.File, s.Number, sectorID, id)
+				mismatches++
+			} else {
+				planned[sectorID] = s.Number
+				actual[s.Number] = sectorID
+			}
+		}
+	}
+	printThroughput(total, time.Since(start))
+	if mismatches > 0 {
+		fmt.Printf("%d pieces did not follow the plan\n", mismatches)
+	}
+
+	if noSeal {
+		return nil
+	}
+	return sealBackend(b, &backend.SealOptions{})
+}
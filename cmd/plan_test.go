@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPlanSectors(t *testing.T) {
+	for _, c := range []struct {
+		strategy string
+		maxBytes uint64
+		sizes    []uint64
+		// sectors are the piece sizes of each planned sector.
+		sectors [][]uint64
+	}{
+		// 200 and 381 are padded to 254 and 508, which do not fit together,
+		// then 127 and 100 fit both sectors.
+		{PackFirstFit, 1000, []uint64{200, 381, 127, 100}, [][]uint64{{200, 127, 100}, {381}}},
+		{PackBestFit, 1000, []uint64{200, 381, 127, 100}, [][]uint64{{200}, {381, 127, 100}}},
+		{PackSizeSorted, 1000, []uint64{200, 381, 127, 100}, [][]uint64{{381, 200, 127}, {100}}},
+
+		{PackFirstFit, 1500, []uint64{200, 600, 600, 100, 381, 600}, [][]uint64{{200, 100, 381}, {600}, {600}, {600}}},
+		{PackBestFit, 1500, []uint64{200, 600, 600, 100, 381, 600}, [][]uint64{{200, 381}, {600, 100}, {600}, {600}}},
+		{PackSizeSorted, 1500, []uint64{200, 600, 600, 100, 381, 600}, [][]uint64{{600, 200, 100}, {600}, {600}, {381}}},
+
+		// Whole sectors and exact fills.
+		{PackFirstFit, 1016, []uint64{1016, 508, 508}, [][]uint64{{1016}, {508, 508}}},
+		{PackBestFit, 1016, []uint64{508, 1016, 254, 254}, [][]uint64{{508, 254, 254}, {1016}}},
+		{PackSizeSorted, 1016, []uint64{127, 1016, 127}, [][]uint64{{1016}, {127, 127}}},
+	} {
+		name := fmt.Sprintf("%s/%d/%v", c.strategy, c.maxBytes, c.sizes)
+		var pieces []*PlannedPiece
+		for i, size := range c.sizes {
+			pieces = append(pieces, &PlannedPiece{File: fmt.Sprint(i), Size: size})
+		}
+		sectors, err := planSectors(pieces, c.maxBytes, c.strategy)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		var got [][]uint64
+		for _, s := range sectors {
+			var sizes []uint64
+			for _, p := range s.Pieces {
+				sizes = append(sizes, p.Size)
+			}
+			got = append(got, sizes)
+			if s.Written > c.maxBytes {
+				t.Fatalf("%s: sector %d takes %d bytes, over %d", name, s.Number, s.Written, c.maxBytes)
+			}
+		}
+		if !reflect.DeepEqual(got, c.sectors) {
+			t.Fatalf("%s: planned %v, expected %v", name, got, c.sectors)
+		}
+	}
+}
+
+func TestPlanSectorsErrors(t *testing.T) {
+	_, err := planSectors([]*PlannedPiece{{File: "big", Size: 1017}}, 1016, PackFirstFit)
+	if err == nil {
+		t.Fatal("planning a piece larger than a sector succeeded")
+	}
+	_, err = planSectors([]*PlannedPiece{{File: "empty"}}, 1016, PackFirstFit)
+	if err == nil {
+		t.Fatal("planning an empty piece succeeded")
+	}
+	_, err = planSectors(nil, 1016, "worst-fit")
+	if err == nil {
+		t.Fatal("planning by an unknown strategy succeeded")
+	}
+}
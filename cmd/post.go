@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var postSeed string
+var postSeedFrom string
+var postRounds int
+var postFaults []uint
+
+// addPoStFlags adds the challenge flags shared by the verify-sectors-post
+// commands of both sector builders.
+func addPoStFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&postSeed, "seed", "", "The hex encoded challenge seed to reuse, random if not given")
+	cmd.Flags().StringVar(&postSeedFrom, "seed-from", "", "The hex encoded tipset ticket to derive the challenge seed from, as the chain does")
+	cmd.Flags().IntVar(&postRounds, "rounds", 1, "The number of challenges to run in a row")
+	cmd.Flags().UintSliceVar(&postFaults, "fault", nil, "The sector IDs to declare faulty, which are not challenged")
+}
+
+// PoStSeeds produces the challenge seeds of successive PoSt rounds. A given
+// seed is used for the first round and each next seed is the SHA256 of the
+// previous one, so that a whole run is reproducible from its first seed.
+type PoStSeeds struct {
+	next   types.PoStChallengeSeed
+	random bool
+}
+
+func newPoStSeeds(seedHex, ticketHex string) (*PoStSeeds, error) {
+	s := &PoStSeeds{}
+	switch {
+	case seedHex != "" && ticketHex != "":
+		return nil, errors.New("--seed and --seed-from are mutually exclusive")
+	case seedHex != "":
+		if err := decodeHexInto("challenge seed", seedHex, s.next[:]); err != nil {
+			return nil, err
+		}
+	case ticketHex != "":
+		ticket, err := decodeHex("tipset ticket", ticketHex)
+		if err != nil {
+			return nil, err
+		}
+		s.next = postSeedFromTicket(ticket)
+	default:
+		s.random = true
+	}
+	return s, nil
+}
+
+// postSeedFromTicket derives the challenge seed from the ticket sampled from
+// the chain, the same as the storage miner, which takes its leading bytes.
+func postSeedFromTicket(ticket []byte) types.PoStChallengeSeed {
+	var seed types.PoStChallengeSeed
+	copy(seed[:], ticket)
+	return seed
+}
+
+func (s *PoStSeeds) Next() (types.PoStChallengeSeed, error) {
+	if s.random {
+		var seed types.PoStChallengeSeed
+		_, err := io.ReadFull(rand.Reader, seed[:])
+		return seed, err
+	}
+	seed := s.next
+	s.next = sha256.Sum256(seed[:])
+	return seed, nil
+}
+
+// runPoStRounds challenges all sealed sectors of the sector builder for
+// --rounds rounds, verifying each proof, and returns the number of failed
+// rounds.
+func runPoStRounds(b backend.SectorBuilder, verifier backend.Verifier) (failed int, err error) {
+	if postRounds < 1 {
+		return 0, fmt.Errorf("invalid rounds %d", postRounds)
+	}
+	seeds, err := newPoStSeeds(postSeed, postSeedFrom)
+	if err != nil {
+		return 0, err
+	}
+
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return 0, err
+	}
+	var sectorIDs []string
+	for _, s := range sealed {
+		sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
+	}
+	fmt.Printf("Challenged sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
+
+	faults := append([]uint64{}, uintsToUint64s(postFaults)...)
+	if len(faults) > 0 {
+		var ids []string
+		for _, id := range faults {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		fmt.Printf("Declared faults: [%s]\n", yellow(strings.Join(ids, ", ")))
+	}
+
+	var generateTimes, verifyTimes []time.Duration
+	for i := 0; i < postRounds; i++ {
+		seed, err := seeds.Next()
+		if err != nil {
+			return failed, err
+		}
+		if postRounds > 1 {
+			fmt.Printf("Round %d/%d, ", i+1, postRounds)
+		}
+		fmt.Printf("use challenge seed: %s\n", hex.EncodeToString(seed[:]))
+
+		fmt.Println("Generate PoSt ...")
+		t := time.Now()
+		proof, err := b.GeneratePoSt(seed, nil, faults)
+		if err != nil {
+			fmt.Printf("  error %s\n", red(err))
+			failed++
+			continue
+		}
+		generateTimes = append(generateTimes, time.Since(t))
+		fmt.Printf("  proof %s, took %v\n", hex.EncodeToString(proof), time.Since(t))
+
+		fmt.Println("Verify PoSt ...")
+		t = time.Now()
+		valid, err := verifier.VerifyPoSt(sectorSize.Uint64(), seed, sealed, faults, proof)
+		if err != nil {
+			fmt.Printf("  error %s\n", red(err))
+			failed++
+			continue
+		}
+		verifyTimes = append(verifyTimes, time.Since(t))
+		if !valid {
+			fmt.Print(red("  invalid"))
+			failed++
+		} else {
+			fmt.Print("  valid")
+		}
+		fmt.Printf(", took %v\n", time.Since(t))
+	}
+
+	if postRounds > 1 {
+		fmt.Printf("Rounds: %d, passed %s, failed %s\n", postRounds,
+			green(postRounds-failed), red(failed))
+		printDurationPercentiles("Generate", generateTimes)
+		printDurationPercentiles("Verify", verifyTimes)
+	}
+	return failed, nil
+}
+
+func printDurationPercentiles(label string, durations []time.Duration) {
+	if len(durations) == 0 {
+		return
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	fmt.Printf("  %s: min %v, p50 %v, p90 %v, p99 %v, max %v\n", label,
+		sorted[0], percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[len(sorted)-1])
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// verifySectorsPoSt runs the PoSt rounds of verify-sectors-post on the sector
+// builder of the backend, and fails if any round failed.
+func verifySectorsPoSt(name string) error {
+	bk, b, err := openBackend(name, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+
+	failed, err := runPoStRounds(b, bk.Verifier)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d PoSt rounds failed", failed, postRounds)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+// progressSink receives the progress lines instead of stdout if set, as the
+// daemon passes them to the client waiting for the job.
+var progressSink struct {
+	sync.Mutex
+	fn func(line string)
+}
+
+func setProgressSink(fn func(line string)) {
+	progressSink.Lock()
+	defer progressSink.Unlock()
+	progressSink.fn = fn
+}
+
+// printProgressLine prints the self-updating progress line, or passes it to
+// the progress sink.
+func printProgressLine(line string) {
+	progressSink.Lock()
+	defer progressSink.Unlock()
+	if progressSink.fn != nil {
+		progressSink.fn(line)
+		return
+	}
+	fmt.Printf("\r%s\033[K", line)
+}
+
+// Progress prints a self-updating line with transferred bytes, rate and ETA of
+// a long running data transfer.
+type Progress struct {
+	label string
+	total uint64
+	start time.Time
+
+	lk        sync.Mutex
+	done      uint64
+	lastPrint time.Time
+}
+
+func newProgress(label string, total uint64) *Progress {
+	now := time.Now()
+	return &Progress{
+		label:     label,
+		total:     total,
+		start:     now,
+		lastPrint: now,
+	}
+}
+
+// Add records n more transferred bytes.
+func (p *Progress) Add(n uint64) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.done += n
+	p.print(false)
+}
+
+// Set records the total transferred bytes so far.
+func (p *Progress) Set(n uint64) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.done = n
+	p.print(false)
+}
+
+func (p *Progress) print(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.lastPrint) < progressInterval {
+		return
+	}
+	p.lastPrint = now
+
+	elapsed := now.Sub(p.start)
+	rate := float64(p.done) / elapsed.Seconds()
+	line := fmt.Sprintf("%s: %s", p.label, backend.FormatBytes(p.done))
+	if p.total > 0 {
+		line += fmt.Sprintf(" / %s (%.1f%%)", backend.FormatBytes(p.total), 100*float64(p.done)/float64(p.total))
+	}
+	line += fmt.Sprintf(", %s/s", backend.FormatBytes(uint64(rate)))
+	if p.total > p.done && rate > 0 {
+		eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+	}
+	printProgressLine(line)
+}
+
+// Finish prints the final transfer summary and returns the elapsed time.
+func (p *Progress) Finish() time.Duration {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	elapsed := time.Since(p.start)
+	summary := fmt.Sprintf("%s: %s in %v, %s/s", p.label, backend.FormatBytes(p.done), elapsed, backend.FormatBytes(uint64(float64(p.done)/elapsed.Seconds())))
+	progressSink.Lock()
+	defer progressSink.Unlock()
+	if progressSink.fn != nil {
+		// The summary is logged as a plain line, and the client only sees the
+		// line cleared.
+		progressSink.fn("")
+		fmt.Println(summary)
+		return elapsed
+	}
+	fmt.Printf("\r%s\033[K\n", summary)
+	return elapsed
+}
+
+// backendProgress prints the progress the sector builder backends report.
+func backendProgress(label string, total uint64) backend.Progress {
+	return finishedProgress{newProgress(label, total)}
+}
+
+// finishedProgress is a Progress whose Finish drops the elapsed time.
+type finishedProgress struct {
+	*Progress
+}
+
+func (p finishedProgress) Finish() {
+	p.Progress.Finish()
+}
+
+// Reader wraps r to record the bytes read from it.
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(uint64(n))
+	return n, err
+}
+
+// dirSize returns the total size of all regular files under dir.
+func dirSize(dir string) uint64 {
+	var size uint64
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
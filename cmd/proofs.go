@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/proofs/verification"
+	"github.com/filecoin-project/go-filecoin/types"
+	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// SealProofInput is the input of proofs verify-seal. Byte fields are hex
+// encoded, so that proofs can be copied from chain messages or logs.
+type SealProofInput struct {
+	CommD     string
+	CommR     string
+	CommRStar string
+	Proof     string
+	// ProverID is the hex encoded 31 bytes prover ID. Miner is used to derive
+	// it when ProverID is empty.
+	ProverID   string `json:",omitempty"`
+	Miner      string `json:",omitempty"`
+	SectorID   uint64
+	SectorSize string `json:",omitempty"`
+}
+
+// PoStProofInput is the input of proofs verify-post.
+type PoStProofInput struct {
+	ChallengeSeed string
+	Sectors       []PoStSectorInput
+	Faults        []uint64 `json:",omitempty"`
+	Proof         string
+	SectorSize    string `json:",omitempty"`
+}
+
+type PoStSectorInput struct {
+	SectorID uint64
+	CommR    string
+}
+
+var sealInput SealProofInput
+var postInput PoStProofInput
+var proofsPoStSectors []string
+var proofsPoStFaults []uint
+var proofsInputFile string
+
+func init() {
+	rootCmd.AddCommand(ProofsCmd)
+
+	ProofsCmd.AddCommand(ProofsVerifySealCmd)
+	ProofsCmd.AddCommand(ProofsVerifyPoStCmd)
+
+	ProofsVerifySealCmd.Flags().StringVar(&proofsInputFile, "file", "", "JSON file with the proof inputs, flags override values in it")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.CommD, "comm-d", "", "The hex encoded CommD")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.CommR, "comm-r", "", "The hex encoded CommR")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.CommRStar, "comm-r-star", "", "The hex encoded CommRStar")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.Proof, "proof", "", "The hex encoded PoRep proof")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.ProverID, "prover-id", "", "The hex encoded 31 bytes prover ID")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.Miner, "miner", "", "The miner address to derive the prover ID from, if --prover-id is not given")
+	ProofsVerifySealCmd.Flags().Uint64Var(&sealInput.SectorID, "sector-id", 0, "The sector ID")
+	ProofsVerifySealCmd.Flags().StringVar(&sealInput.SectorSize, "sector-size", "", "The sector size, 256MiB, 1KiB or bytes (default 256MiB)")
+
+	ProofsVerifyPoStCmd.Flags().StringVar(&proofsInputFile, "file", "", "JSON file with the proof inputs, flags override values in it")
+	ProofsVerifyPoStCmd.Flags().StringVar(&postInput.ChallengeSeed, "challenge-seed", "", "The hex encoded challenge seed")
+	ProofsVerifyPoStCmd.Flags().StringSliceVar(&proofsPoStSectors, "sector", nil, "The challenged sectors as <sector-id>:<hex-comm-r>")
+	ProofsVerifyPoStCmd.Flags().UintSliceVar(&proofsPoStFaults, "fault", nil, "The declared faulty sector IDs")
+	ProofsVerifyPoStCmd.Flags().StringVar(&postInput.Proof, "proof", "", "The hex encoded PoSt proof")
+	ProofsVerifyPoStCmd.Flags().StringVar(&postInput.SectorSize, "sector-size", "", "The sector size, 256MiB, 1KiB or bytes (default 256MiB)")
+}
+
+var ProofsCmd = &cobra.Command{
+	Use:   "proofs",
+	Short: "Verify proofs supplied from outside of filutil",
+	Long:  "Verify proofs supplied from outside of filutil, independent of any local sector builder",
+}
+
+var ProofsVerifySealCmd = &cobra.Command{
+	Use:   "verify-seal",
+	Short: "Verify a PoRep (Proof-of-Replication) given by flags or JSON file",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		input := sealInput
+		if proofsInputFile != "" {
+			input = SealProofInput{}
+			err = readProofsInputFile(proofsInputFile, &input)
+			if err != nil {
+				return
+			}
+			flags := cmd.Flags()
+			overrideString(flags.Changed("comm-d"), &input.CommD, sealInput.CommD)
+			overrideString(flags.Changed("comm-r"), &input.CommR, sealInput.CommR)
+			overrideString(flags.Changed("comm-r-star"), &input.CommRStar, sealInput.CommRStar)
+			overrideString(flags.Changed("proof"), &input.Proof, sealInput.Proof)
+			overrideString(flags.Changed("prover-id"), &input.ProverID, sealInput.ProverID)
+			overrideString(flags.Changed("miner"), &input.Miner, sealInput.Miner)
+			overrideString(flags.Changed("sector-size"), &input.SectorSize, sealInput.SectorSize)
+			if flags.Changed("sector-id") {
+				input.SectorID = sealInput.SectorID
+			}
+		}
+
+		req, err := input.request()
+		if err != nil {
+			return
+		}
+
+		fmt.Printf("Verify seal of sector %d, sector size %d\n", req.SectorID, req.SectorSize.Uint64())
+		fmt.Printf("  CommD:     %s\n", hex.EncodeToString(req.CommD[:]))
+		fmt.Printf("  CommR:     %s\n", hex.EncodeToString(req.CommR[:]))
+		fmt.Printf("  CommRStar: %s\n", hex.EncodeToString(req.CommRStar[:]))
+		fmt.Printf("  Prover ID: %s\n", hex.EncodeToString(req.ProverID[:]))
+		fmt.Printf("  Proof:     %d bytes\n", len(req.Proof))
+		t := time.Now()
+		res, err := (&verification.RustVerifier{}).VerifySeal(*req)
+		if err != nil {
+			return
+		}
+		printProofValidity(res.IsValid, time.Since(t))
+		if !res.IsValid {
+			os.Exit(1)
+		}
+	},
+}
+
+var ProofsVerifyPoStCmd = &cobra.Command{
+	Use:   "verify-post",
+	Short: "Verify a PoSt (Proof-of-Spacetime) given by flags or JSON file",
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		input := postInput
+		if proofsInputFile != "" {
+			input = PoStProofInput{}
+			err = readProofsInputFile(proofsInputFile, &input)
+			if err != nil {
+				return
+			}
+			flags := cmd.Flags()
+			overrideString(flags.Changed("challenge-seed"), &input.ChallengeSeed, postInput.ChallengeSeed)
+			overrideString(flags.Changed("proof"), &input.Proof, postInput.Proof)
+			overrideString(flags.Changed("sector-size"), &input.SectorSize, postInput.SectorSize)
+		}
+		if len(proofsPoStSectors) > 0 {
+			input.Sectors = nil
+			for _, s := range proofsPoStSectors {
+				parts := strings.SplitN(s, ":", 2)
+				if len(parts) != 2 {
+					err = fmt.Errorf("invalid sector %q, expect <sector-id>:<hex-comm-r>", s)
+					return
+				}
+				var id uint64
+				id, err = strconv.ParseUint(parts[0], 10, 64)
+				if err != nil {
+					err = errors.Wrapf(err, "invalid sector ID in %q", s)
+					return
+				}
+				input.Sectors = append(input.Sectors, PoStSectorInput{SectorID: id, CommR: parts[1]})
+			}
+		}
+		if len(proofsPoStFaults) > 0 {
+			input.Faults = uintsToUint64s(proofsPoStFaults)
+		}
+
+		req, err := input.request()
+		if err != nil {
+			return
+		}
+
+		var sectorIDs []string
+		for _, s := range req.SortedSectorInfo.Values() {
+			sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
+		}
+		var faults []string
+		for _, id := range req.Faults {
+			faults = append(faults, fmt.Sprint(id))
+		}
+		fmt.Printf("Verify PoSt, sector size %d\n", req.SectorSize.Uint64())
+		fmt.Printf("  Challenge seed: %s\n", hex.EncodeToString(req.ChallengeSeed[:]))
+		fmt.Printf("  Sectors:        [%s]\n", blue(strings.Join(sectorIDs, ", ")))
+		fmt.Printf("  Faults:         [%s]\n", strings.Join(faults, ", "))
+		fmt.Printf("  Proof:          %d bytes\n", len(req.Proof))
+		t := time.Now()
+		res, err := (&verification.RustVerifier{}).VerifyPoSt(*req)
+		if err != nil {
+			return
+		}
+		printProofValidity(res.IsValid, time.Since(t))
+		if !res.IsValid {
+			os.Exit(1)
+		}
+	},
+}
+
+func (input *SealProofInput) request() (*verification.VerifySealRequest, error) {
+	req := &verification.VerifySealRequest{
+		SectorID: input.SectorID,
+	}
+	var err error
+	if err = decodeHexInto("CommD", input.CommD, req.CommD[:]); err != nil {
+		return nil, err
+	}
+	if err = decodeHexInto("CommR", input.CommR, req.CommR[:]); err != nil {
+		return nil, err
+	}
+	if err = decodeHexInto("CommRStar", input.CommRStar, req.CommRStar[:]); err != nil {
+		return nil, err
+	}
+	req.Proof, err = decodeHex("proof", input.Proof)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case input.ProverID != "":
+		if err = decodeHexInto("prover ID", input.ProverID, req.ProverID[:]); err != nil {
+			return nil, err
+		}
+	case input.Miner != "":
+		addr, err := address.NewFromString(input.Miner)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid miner address")
+		}
+		req.ProverID = sectorbuilder.AddressToProverID(addr)
+	default:
+		return nil, errors.New("prover ID or miner address is required")
+	}
+	req.SectorSize, err = parseSectorSize(input.SectorSize)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (input *PoStProofInput) request() (*verification.VerifyPoStRequest, error) {
+	req := &verification.VerifyPoStRequest{
+		Faults: input.Faults,
+	}
+	if req.Faults == nil {
+		req.Faults = []uint64{}
+	}
+	var err error
+	if err = decodeHexInto("challenge seed", input.ChallengeSeed, req.ChallengeSeed[:]); err != nil {
+		return nil, err
+	}
+	if len(input.Sectors) == 0 {
+		return nil, errors.New("at least one challenged sector is required")
+	}
+	var sectorInfos []go_sectorbuilder.SectorInfo
+	for _, s := range input.Sectors {
+		info := go_sectorbuilder.SectorInfo{SectorID: s.SectorID}
+		if err = decodeHexInto(fmt.Sprintf("CommR of sector %d", s.SectorID), s.CommR, info.CommR[:]); err != nil {
+			return nil, err
+		}
+		sectorInfos = append(sectorInfos, info)
+	}
+	req.SortedSectorInfo = go_sectorbuilder.NewSortedSectorInfo(sectorInfos...)
+	req.Proof, err = decodeHex("proof", input.Proof)
+	if err != nil {
+		return nil, err
+	}
+	req.SectorSize, err = parseSectorSize(input.SectorSize)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func readProofsInputFile(filename string, v interface{}) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode %s", filename)
+	}
+	return nil
+}
+
+func overrideString(changed bool, dst *string, v string) {
+	if changed {
+		*dst = v
+	}
+}
+
+func decodeHex(name, s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("%s is required", name)
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", name)
+	}
+	return b, nil
+}
+
+// decodeHexInto decodes s into dst, which must be exactly filled.
+func decodeHexInto(name, s string, dst []byte) error {
+	b, err := decodeHex(name, s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(dst) {
+		return fmt.Errorf("invalid %s, %d bytes, expected %d", name, len(b), len(dst))
+	}
+	copy(dst, b)
+	return nil
+}
+
+// parseSectorSize parses a sector size name or bytes, and defaults to the
+// sector size of the sector builders, set by the config.
+func parseSectorSize(s string) (*types.BytesAmount, error) {
+	switch s {
+	case "":
+		return sectorSize, nil
+	case "256MiB":
+		return types.TwoHundredFiftySixMiBSectorSize, nil
+	case "1KiB":
+		return types.OneKiBSectorSize, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sector size %q", s)
+	}
+	return types.NewBytesAmount(n), nil
+}
+
+func printProofValidity(valid bool, took time.Duration) {
+	if valid {
+		fmt.Print(green("valid"))
+	} else {
+		fmt.Print(red("invalid"))
+	}
+	fmt.Printf(", took %v\n", took)
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/meta"
 )
 
 var repoDir string
@@ -35,6 +37,7 @@ func getFilutilDir() string {
 func init() {
 	rootCmd.PersistentFlags().StringVar(&repoDir, "repodir", "", "The directory of the filecoin repo")
 	rootCmd.PersistentFlags().StringVar(&filutilDir, "filutildir", "", "The directory of the filutil metadata")
+	rootCmd.PersistentFlags().BoolVar(&meta.LockWait, "wait", false, "Wait for the filutil directory locked by another filutil process instead of failing")
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -47,12 +50,33 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	hookConfig(rootCmd)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+// aliasCommand returns a copy of the command c to add under another parent
+// command, without the flags of c.
+func aliasCommand(c *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:   c.Use,
+		Short: c.Short,
+		Long:  c.Long,
+		Args:  c.Args,
+		Run:   c.Run,
+	}
+}
+
+// exitOnError prints err and exits with failure if err is not nil.
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
 var black = color.New(color.FgBlack).SprintFunc()
 var red = color.New(color.FgRed).SprintFunc()
 var green = color.New(color.FgGreen).SprintFunc()
@@ -1,43 +1,53 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-datastore"
-	"github.com/ipfs/go-datastore/query"
 	badgerds "github.com/ipfs/go-ds-badger"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
-	cbor "github.com/ipfs/go-ipld-cbor"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/plumbing/dag"
 	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
-	"github.com/filecoin-project/go-filecoin/proofs/verification"
 	"github.com/filecoin-project/go-filecoin/repo"
-	"github.com/filecoin-project/go-filecoin/types"
 	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
+
+	"github.com/filcloud/filutil/backend"
+	sbbackend "github.com/filcloud/filutil/backend/sectorbuilder"
+	"github.com/filcloud/filutil/meta"
 )
 
 var pieceNum int
-
-var minerAddr address.Address
+var noSeal bool
+var sealSectors []uint
+var sealMinFill float64
+var sealResume bool
+var sealRetryFailed bool
+var exportOutput string
+var exportSectors []uint
+var importForce bool
+var unsealPiece string
+var unsealOutput string
+
+var minerAddr = sbbackend.DefaultMinerAddr
+
+// sectorSize is the sector size of both sector builders.
+var sectorSize = sbbackend.DefaultSectorSize
 
 func init() {
 	rootCmd.AddCommand(SectorBuilderCmd)
@@ -46,22 +56,40 @@ func init() {
 	SectorBuilderCmd.AddCommand(SectorBuilderGenPieceCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderLsPiecesCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderGetPiecesCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderCommPCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderSealSectorsCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderLsSectorsCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderSectorInfoCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderExportSectorsCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderImportSectorsCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderCorruptSectorCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderCheckSectorsCmd)
+	SectorBuilderCmd.AddCommand(SectorBuilderUnsealCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderVerifySectorsPorepCmd)
 	SectorBuilderCmd.AddCommand(SectorBuilderVerifySectorsPostCmd)
 
 	SectorBuilderGenPieceCmd.Flags().IntVarP(&pieceNum, "piece-num", "n", 1, "The number of pieces to generate")
-
-	var err error
-	minerAddr, err = address.NewActorAddress([]byte("filutilminer"))
-	if err != nil {
-		panic(err)
-	}
+	SectorBuilderAddPieceCmd.Flags().BoolVar(&noSeal, "no-seal", false, "Do not seal staged sectors after adding, but full sectors are still sealed by the sector builder")
+	SectorBuilderGenPieceCmd.Flags().BoolVar(&noSeal, "no-seal", false, "Do not seal staged sectors after adding, but full sectors are still sealed by the sector builder")
+	addGenPieceFlags(SectorBuilderGenPieceCmd)
+	SectorBuilderSealSectorsCmd.Flags().UintSliceVar(&sealSectors, "sector", nil, "The staged sectors to seal, not supported by the sector builder, which seals all staged sectors at once")
+	SectorBuilderSealSectorsCmd.Flags().BoolVar(&sealResume, "resume", false, "Seal again the sectors left in sealing state by a dead process, not supported by the sector builder")
+	SectorBuilderSealSectorsCmd.Flags().BoolVar(&sealRetryFailed, "retry-failed", false, "Seal again the sectors which failed sealing")
+	SectorBuilderSealSectorsCmd.Flags().Float64Var(&sealMinFill, "min-fill", 0, "Only seal if all unsealed staged sectors are filled at least this percent, not supported by the sector builder")
+	SectorBuilderExportSectorsCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "The bundle file to export into")
+	SectorBuilderExportSectorsCmd.Flags().UintSliceVar(&exportSectors, "sector", nil, "The sealed sectors to export, defaults to all sealed sectors")
+	_ = SectorBuilderExportSectorsCmd.MarkFlagRequired("output")
+	SectorBuilderImportSectorsCmd.Flags().BoolVar(&importForce, "force", false, "Overwrite sealed sectors which already exist")
+	addImportFlags(SectorBuilderAddPieceCmd)
+	addPoStFlags(SectorBuilderVerifySectorsPostCmd)
+	addCorruptFlags(SectorBuilderCorruptSectorCmd)
+	_ = SectorBuilderCorruptSectorCmd.MarkFlagRequired("replica")
+	SectorBuilderUnsealCmd.Flags().StringVar(&unsealPiece, "piece", "", "The cid of the piece to unseal, required if the sector holds more than one piece")
+	SectorBuilderUnsealCmd.Flags().StringVarP(&unsealOutput, "output", "o", "", "The file to save the unsealed piece into, defaults to the piece cid")
 }
 
 var SectorBuilderCmd = &cobra.Command{
-	Use:   "sector-builder",
+	Use:   sbbackend.Name,
 	Short: "Commands for filecoin sector builder",
 }
 
@@ -70,9 +98,11 @@ type DAG struct {
 	dagService   format.DAGService
 	blockService blockservice.BlockService
 	datastore    repo.Datastore
+	lock         *meta.RepoLock
 }
 
 func (d *DAG) Close() {
+	defer d.lock.Release()
 	err := d.blockService.Close()
 	if err != nil {
 		panic(err)
@@ -83,11 +113,16 @@ func (d *DAG) Close() {
 	}
 }
 
-func openSectorBuilderPiecesDAG() *DAG {
+func openSectorBuilderPiecesDAG() (*DAG, error) {
+	lock, err := meta.LockRepo(getFilutilDir())
+	if err != nil {
+		return nil, err
+	}
 	options := &badgerds.DefaultOptions
 	piecesStore, err := badgerds.NewDatastore(filepath.Join(getFilutilDir(), "pieces"), options)
 	if err != nil {
-		panic(err)
+		lock.Release()
+		return nil, err
 	}
 	blockStore := blockstore.NewBlockstore(piecesStore)
 	blockService := blockservice.New(blockStore, offline.Exchange(blockStore))
@@ -98,12 +133,14 @@ func openSectorBuilderPiecesDAG() *DAG {
 		dagService:   dagService,
 		blockService: blockService,
 		datastore:    piecesStore,
-	}
+		lock:         lock,
+	}, nil
 }
 
 var SectorBuilderLsPiecesCmd = &cobra.Command{
 	Use:   "ls-pieces",
 	Short: "List all pieces",
+	Long:  "List all pieces with their metadata, optionally filtered by labels and sorted.",
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
 		defer func() {
@@ -113,31 +150,58 @@ var SectorBuilderLsPiecesCmd = &cobra.Command{
 			}
 		}()
 
-		ds := openMetaDatastore()
+		ds, err := meta.Open(getFilutilDir())
+		if err != nil {
+			return
+		}
 		defer ds.Close()
-		dag := openSectorBuilderPiecesDAG()
+		dag, err := openSectorBuilderPiecesDAG()
+		if err != nil {
+			return
+		}
 		defer dag.Close()
 
-		result, err := ds.Query(query.Query{
-			Prefix:   metaSectorBuilderPiecePrefix,
-			KeysOnly: true,
-		})
+		filters, err := parsePieceFilters(lsPiecesFilters)
 		if err != nil {
 			return
 		}
-
-		for entry := range result.Next() {
-			err = entry.Error
+		records, err := getPieceRecordList(ds)
+		if err != nil {
+			return
+		}
+		for _, r := range records {
+			if r.Size != 0 {
+				continue
+			}
+			var c cid.Cid
+			c, err = cid.Parse(r.Cid)
 			if err != nil {
 				return
 			}
-			var c cid.Cid
-			c, err = cid.Parse(strings.TrimLeft(entry.Key, metaSectorBuilderPiecePrefix+"/"))
+			r.Size, err = dag.PieceSize(c) // not recorded by older imports
+			if err != nil {
+				return
+			}
+		}
+		records, err = filterPieceRecords(records, filters, lsPiecesSort)
+		if err != nil {
+			return
+		}
 
-			r, err := dag.dag.Cat(context.Background(), c)
+		for _, r := range records {
+			var c cid.Cid
+			c, err = cid.Parse(r.Cid)
 			if err != nil {
 				return
 			}
+			commP := "unknown"
+			if r.CommP != "" {
+				commP = r.CommP
+			}
+			meta := formatPieceRecord(r)
+			if meta != "" {
+				meta = ", " + meta
+			}
 
 			var node format.Node
 			node, err = dag.dagService.Get(context.Background(), c)
@@ -151,7 +215,7 @@ var SectorBuilderLsPiecesCmd = &cobra.Command{
 				}
 				s := fmt.Sprintf("data size: %d, links: %d, cumulative size: %d", stat.DataSize, stat.NumLinks, stat.CumulativeSize)
 				if depth == 0 {
-					fmt.Printf("Piece: %s, %s, original data size: %d\n", blue(node.Cid().String()), s, r.Size())
+					fmt.Printf("Piece: %s, %s, original data size: %d, CommP: %s%s\n", blue(node.Cid().String()), s, r.Size, commP, meta)
 				} else {
 					fmt.Printf("%s%s, %s\n", strings.Repeat("  ", depth), red(node.Cid().String()), s)
 				}
@@ -187,6 +251,7 @@ func traverseNode(dagService format.DAGService, node format.Node, depth int, vis
 var SectorBuilderGetPiecesCmd = &cobra.Command{
 	Use:   "get-piece <cid> <file>",
 	Short: "Get piece and save into file",
+	Long:  "Get piece and save into file, or reconstruct a directory piece as the directory.",
 	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
@@ -197,429 +262,580 @@ var SectorBuilderGetPiecesCmd = &cobra.Command{
 			}
 		}()
 
-		dag := openSectorBuilderPiecesDAG()
-		defer dag.Close()
-
-		c, err := cid.Parse(args[0])
+		dag, err := openSectorBuilderPiecesDAG()
 		if err != nil {
 			return
 		}
+		defer dag.Close()
 
-		r, err := dag.dag.Cat(context.Background(), c)
+		c, err := cid.Parse(args[0])
 		if err != nil {
 			return
 		}
+
 		filename := args[0]
 		if len(args) == 2 {
 			filename = args[1]
 		}
-		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+		nd, err := dag.dagService.Get(context.Background(), c)
 		if err != nil {
 			return
 		}
-		n, err := io.Copy(f, r)
-		if err == nil && uint64(n) < r.Size() {
-			err = io.ErrShortWrite
+		if isPieceDir(nd) {
+			err = dag.savePieceDir(c, filename)
+			return
 		}
-		if err1 := f.Close(); err == nil {
-			err = err1
+		r, err := dag.dag.Cat(context.Background(), c)
+		if err != nil {
+			return
 		}
+		err = saveReader(r, r.Size(), filename)
 	},
 }
 
-var SectorBuilderAddPieceCmd = &cobra.Command{
-	Use:   "add-piece <file>",
-	Short: "Add piece",
+var SectorBuilderCommPCmd = &cobra.Command{
+	Use:   "comm-p <file|cid>",
+	Short: "Compute piece commitment (CommP) of a file or a piece",
+	Long:  "Compute piece commitment (CommP) of a local file, or of a piece in the pieces DAG. Data is zero-filled to the unpadded size of the next power-of-two sized piece before Fr32 padding. CommP of a piece is recorded in the piece metadata.",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		filename := args[0]
-		file, err := os.Open(filename)
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
-
-		dag := openSectorBuilderPiecesDAG()
-		defer dag.Close()
-		ds := openMetaDatastore()
-
-		nd, err := dag.dag.ImportData(context.Background(), file)
-		if err != nil {
-			panic(err)
-		}
-		err = ds.Put(makeKey(metaSectorBuilderPiecePrefix, nd.Cid().String()), nil)
-		if err != nil {
-			return
-		}
-
-		ds.Close()
-
-		sb := openSectorBuilder()
-		defer sb.Close()
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
 
-		r, err := dag.dag.Cat(context.Background(), nd.Cid())
-		if err != nil {
-			return
-		}
-		t := time.Now()
-		sectorID, err := sb.AddPiece(context.Background(), nd.Cid(), r.Size(), r)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("Added piece %s into staging sector %d, took %v\n", nd.Cid(), sectorID, time.Since(t))
+		var commP [go_sectorbuilder.CommitmentBytesLen]byte
+		var size uint64
 
-		sb.SealAllStagedUnsealedSectors()
-	},
-}
+		if stat, err1 := os.Stat(args[0]); err1 == nil && !stat.IsDir() {
+			var file *os.File
+			file, err = os.Open(args[0])
+			if err != nil {
+				return
+			}
+			defer file.Close()
 
-var SectorBuilderGenPieceCmd = &cobra.Command{
-	Use:   "generate-piece <file>",
-	Short: "Generate piece",
-	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSectorBuilder()
-		defer sb.Close()
+			size = uint64(stat.Size())
+			commP, err = generatePieceCommitment(file, size)
+			if err != nil {
+				return
+			}
+			fmt.Printf("File %s: ", args[0])
+		} else {
+			var c cid.Cid
+			c, err = cid.Parse(args[0])
+			if err != nil {
+				err = errors.Wrapf(err, "%s is neither a file nor a piece cid", args[0])
+				return
+			}
 
-		for i := 0; i < pieceNum; i++ {
-			pieceData := make([]byte, sb.MaxBytesPerSector.Uint64())
-			_, err := io.ReadFull(rand.Reader, pieceData)
+			var ds *meta.Datastore
+			ds, err = meta.Open(getFilutilDir())
 			if err != nil {
-				panic(err)
+				return
 			}
+			defer ds.Close()
+			var dag *DAG
+			dag, err = openSectorBuilderPiecesDAG()
+			if err != nil {
+				return
+			}
+			defer dag.Close()
 
-			data := merkledag.NewRawNode(pieceData)
+			var r *PieceRecord
+			r, err = getPieceRecord(ds, c)
+			if err != nil {
+				return
+			}
 
-			t := time.Now()
-			sectorID, err := sb.AddPiece(context.Background(), data.Cid(), uint64(len(pieceData)), bytes.NewReader(pieceData))
+			commP, size, err = dag.PieceCommitment(c)
+			if err != nil {
+				return
+			}
+			r.CommP = hex.EncodeToString(commP[:])
+			r.Size = size
+			err = putPieceRecord(ds, r)
 			if err != nil {
-				panic(err)
+				return
 			}
-			fmt.Printf("Generate and add piece %s with size %d into staging sector %d, took %v\n", data.Cid(), len(pieceData), sectorID, time.Since(t))
+			fmt.Printf("Piece %s: ", blue(c.String()))
 		}
 
-		sb.SealAllStagedUnsealedSectors()
+		unpadded := pieceCommitmentUnpaddedSize(size)
+		fmt.Printf("size %d, unpadded size %d, padded size %d, CommP %s\n", size, unpadded, unpadded/127*128, hex.EncodeToString(commP[:]))
 	},
 }
 
-type SectorBuilder struct {
-	sectorbuilder.SectorBuilder
-	MetaStore         *Datastore
-	MaxBytesPerSector *types.BytesAmount
+// pieceCommitmentUnpaddedSize returns the unpadded size of the smallest
+// power-of-two sized piece which holds size bytes after Fr32 padding.
+// Fr32 padding expands every 127 bytes to 128 bytes.
+func pieceCommitmentUnpaddedSize(size uint64) uint64 {
+	padded := uint64(128)
+	for padded/128*127 < size {
+		padded <<= 1
+	}
+	return padded / 128 * 127
 }
 
-func (sb *SectorBuilder) Close() {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for range sb.SectorSealResults() {
+// generatePieceCommitment stages size bytes of piece data into a zero-filled
+// temp file and computes its CommP.
+func generatePieceCommitment(r io.Reader, size uint64) (commP [go_sectorbuilder.CommitmentBytesLen]byte, err error) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		return commP, err
+	}
+
+	defer func() {
+		err1 := os.Remove(file.Name())
+		if err1 != nil && err == nil {
+			err = err1
 		}
-		wg.Done()
 	}()
-	err := sb.SectorBuilder.Close()
+
+	n, err := io.Copy(file, r)
+	if err == nil && uint64(n) != size {
+		err = fmt.Errorf("was unable to write all piece bytes to temp file (wrote %dB, pieceSize %dB)", n, size)
+	}
+	unpadded := pieceCommitmentUnpaddedSize(size)
+	if err == nil {
+		err = file.Truncate(int64(unpadded))
+	}
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
 	if err != nil {
-		panic(err)
+		return commP, err
 	}
-	wg.Wait()
-	sb.MetaStore.Close()
+
+	return go_sectorbuilder.GeneratePieceCommitment(file.Name(), unpadded)
 }
 
-func (sb *SectorBuilder) SealAllStagedUnsealedSectors() {
-	allStaged, err := sb.GetAllStagedSectors()
+// PieceCommitment computes CommP of the piece c in the DAG, and returns it with
+// the original data size of the piece.
+func (d *DAG) PieceCommitment(c cid.Cid) (commP [go_sectorbuilder.CommitmentBytesLen]byte, size uint64, err error) {
+	r, size, err := d.OpenPiece(c)
 	if err != nil {
-		panic(err)
-	}
-	var sectorIDs []string
-	sectorIDSet := map[uint64]struct{}{}
-	for _, s := range allStaged {
-		sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
-		sectorIDSet[s.SectorID] = struct{}{}
+		return commP, 0, err
 	}
+	defer r.Close()
+	commP, err = generatePieceCommitment(r, size)
+	return commP, size, err
+}
+
+var SectorBuilderAddPieceCmd = &cobra.Command{
+	Use:   "add-piece <file|dir>",
+	Short: "Add piece",
+	Long:  "Add a file as a piece. With --recursive, a directory tree is added as one UnixFS directory piece whose piece data is a tar archive of the tree, or as one piece per file with --per-file.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if submitDaemonJob(&Job{Kind: JobAddPiece, Builder: SectorBuilderCmd.Use, File: args[0], Recursive: pieceRecursive, PerFile: piecePerFile, Import: &pieceImportOptions, NoSeal: noSeal}) {
+			return
+		}
+		exitOnError(addPieces(SectorBuilderCmd.Use, args[0], noSeal, 1))
+	},
+}
 
-	allSealed := getSealedSectorMetadataList(sb.MetaStore)
-	var sealedSectorIDs []string
-	for _, s := range allSealed {
-		sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(s.SectorID))
-		delete(sectorIDSet, s.SectorID)
+// importPiece imports the file into the pieces DAG by opts, and records the
+// piece with its CommP and path in the meta datastore.
+func importPiece(dag *DAG, ds *meta.Datastore, filename string, opts ImportOptions) (c cid.Cid, size uint64, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return cid.Undef, 0, err
 	}
 
-	fmt.Println("Seal all staged sectors ...")
-	fmt.Printf("  staged sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
-	fmt.Printf("  but sealed sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
+	c, err = importPieceData(dag, ds, file, uint64(stat.Size()), filename, opts)
+	return c, uint64(stat.Size()), err
+}
 
-	if len(sectorIDSet) == 0 {
-		fmt.Println("No staged sector needs to seal")
-		return
+// importPieceData imports the piece data of size bytes read from r into the
+// pieces DAG by opts, and records the piece with its CommP and path in the meta
+// datastore. The path may be empty for pieces not imported from files.
+func importPieceData(dag *DAG, ds *meta.Datastore, r io.Reader, size uint64, path string, opts ImportOptions) (cid.Cid, error) {
+	progress := newProgress("Importing into pieces DAG", size)
+	nd, err := dag.importData(progress.Reader(r), opts)
+	if err != nil {
+		return cid.Undef, err
 	}
+	progress.Finish()
 
-	err = sb.SealAllStagedSectors(context.Background())
+	commP, _, err := dag.PieceCommitment(nd.Cid())
 	if err != nil {
-		panic(err)
+		return cid.Undef, err
+	}
+	err = recordPiece(ds, nd.Cid(), size, commP[:], path, opts)
+	if err != nil {
+		return cid.Undef, err
 	}
+	fmt.Printf("Imported piece %s, CommP %s\n", nd.Cid(), hex.EncodeToString(commP[:]))
 
-	t := time.Now()
-	for val := range sb.SectorSealResults() {
-		if _, ok := sectorIDSet[val.SectorID]; !ok {
-			t = time.Now()
-			continue
-		}
+	return nd.Cid(), nil
+}
 
-		sb.HandleSectorSealResult(&val, t)
+// printThroughput prints the overall throughput of adding a piece.
+func printThroughput(size uint64, elapsed time.Duration) {
+	fmt.Printf("Total: %s in %v, %s/s\n", backend.FormatBytes(size), elapsed, backend.FormatBytes(uint64(float64(size)/elapsed.Seconds())))
+}
 
-		delete(sectorIDSet, val.SectorID)
-		if len(sectorIDSet) == 0 {
-			break
-		}
+var SectorBuilderGenPieceCmd = &cobra.Command{
+	Use:   "generate-piece",
+	Short: "Generate piece",
+	Long:  "Generate synthetic pieces of the sizes and content pattern given by the flags, and add them into staged sectors. Sizes and the seeded and text patterns are reproducible by --seed.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(generatePieces(cmd, SectorBuilderCmd.Use, pieceNum, noSeal))
+	},
+}
 
-		t = time.Now()
+func uintsToUint64s(vs []uint) []uint64 {
+	var result []uint64
+	for _, v := range vs {
+		result = append(result, uint64(v))
 	}
+	return result
 }
 
-func (sb *SectorBuilder) HandleSectorSealResult(r *sectorbuilder.SectorSealResult, startAt time.Time) {
-	if r.SealingErr != nil {
-		fmt.Printf("Sealing %s: sector %d, took %v, error %s\n",
-			red("failed"), r.SectorID, time.Since(startAt), red(r.SealingErr))
-	} else if r.SealingResult != nil {
-		fmt.Printf("Sealing %s: sector %d, took %v\n", blue("succeeded"), r.SectorID, time.Since(startAt))
-		for _, pieceInfo := range r.SealingResult.Pieces {
-			fmt.Printf("  Piece %s, size %d\n", cyan(pieceInfo.Ref), pieceInfo.Size)
+var SectorBuilderSealSectorsCmd = &cobra.Command{
+	Use:   "seal-sectors",
+	Short: "Seal all staged sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := sealOptions()
+		if submitDaemonJob(&Job{Kind: JobSeal, Builder: SectorBuilderCmd.Use, Seal: &opts}) {
+			return
 		}
+		exitOnError(sealStagedSectors(SectorBuilderCmd.Use, &opts))
+	},
+}
 
-		sectorIDStr := fmt.Sprint(r.SectorID)
-		err := sb.MetaStore.Put(datastore.NewKey(metaSectorBuilderLastUsedSectorIDPrefix), []byte(sectorIDStr))
-		if err != nil {
-			fmt.Printf("  Saving LastUsedSectorID %d failed\n", r.SectorID)
+var SectorBuilderVerifySectorsPorepCmd = &cobra.Command{
+	Use:   "verify-sectors-porep",
+	Short: "Verify PoRep (Proof-of-Replication) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		if submitDaemonJob(&Job{Kind: JobVerify, Builder: SectorBuilderCmd.Use}) {
+			return
 		}
-		bytes, err := cbor.DumpObject(r.SealingResult)
+		exitOnError(verifySectorsPoRep(SectorBuilderCmd.Use))
+	},
+}
+
+var SectorBuilderLsSectorsCmd = &cobra.Command{
+	Use:   "ls-sectors",
+	Short: "List all sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(lsSectors(SectorBuilderCmd.Use))
+	},
+}
+
+var SectorBuilderSectorInfoCmd = &cobra.Command{
+	Use:   "sector-info <sector-id>",
+	Short: "Show details of a sector",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		sectorID, err := strconv.ParseUint(args[0], 0, 64)
 		if err != nil {
-			panic(err)
+			return
 		}
-		err = sb.MetaStore.Put(makeKey(metaSectorBuilderSealedSectorMetadataPrefix, sectorIDStr), bytes)
+
+		sb, err := sbbackend.OpenSectorBuilder(builderConfig())
 		if err != nil {
-			fmt.Printf("  Saving SealedSectorMetadata %d failed\n", r.SectorID)
+			return
 		}
-	}
-}
+		defer sb.Close()
 
-func openSectorBuilder() *SectorBuilder {
-	ds := openMetaDatastore()
+		info := &SectorInfo{
+			SectorID:          sectorID,
+			MaxBytesPerSector: sb.MaxBytesPerSector.Uint64(),
+		}
+		info.State, err = meta.GetSectorState(sb.MetaStore, sectorID)
+		if err != nil {
+			return
+		}
 
-	var lastUsedSectorID uint64
-	v, err := ds.Get(datastore.NewKey(metaSectorBuilderLastUsedSectorIDPrefix))
-	if err == nil {
-		lastUsedSectorID, err = strconv.ParseUint(string(v), 0, 64)
+		allStaged, err := sb.GetAllStagedSectors()
 		if err != nil {
-			panic(err)
+			return
+		}
+		for _, s := range allStaged {
+			if s.SectorID == sectorID {
+				info.Staged = true
+			}
+		}
+		for _, s := range sbbackend.SealedSectors(sb.MetaStore) {
+			if s.SectorID != sectorID {
+				continue
+			}
+			info.Sealed = true
+			info.CommD = s.CommD
+			info.CommR = s.CommR
+			info.CommRStar = s.CommRStar
+			info.Proof = s.Proof
+			info.PiecesKnown = true
+			for _, p := range s.Pieces {
+				info.Pieces = append(info.Pieces, SectorPieceInfo{Ref: p.Ref.String(), Size: p.Size})
+			}
+		}
+		if !info.Staged && !info.Sealed {
+			err = fmt.Errorf("sector %d not found", sectorID)
+			return
 		}
-	} else if err != datastore.ErrNotFound {
-		panic(err)
-	}
 
-	stagingDir := filepath.Join(getFilutilDir(), "staging")
-	sealedDir := filepath.Join(getFilutilDir(), "sealed")
+		printSectorInfo(info)
+	},
+}
 
-	sectorClass := types.NewSectorClass(types.TwoHundredFiftySixMiBSectorSize)
+var SectorBuilderCheckSectorsCmd = &cobra.Command{
+	Use:   "check-sectors",
+	Short: "Check sealed sectors for faults",
+	Long:  "Check sealed sectors for faults. The sector builder does not expose sealed replica paths, so replica files cannot be checked directly; instead Merkle challenges of each sector are sampled against its CommR by a single sector PoSt.",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(checkSectors(SectorBuilderCmd.Use, true, SectorBuilderCmd.Use+" verify-sectors-post"))
+	},
+}
 
-	memRepo := repo.NewInMemoryRepo()
-	blockStore := blockstore.NewBlockstore(memRepo.Datastore())
-	blockService := blockservice.New(blockStore, offline.Exchange(blockStore))
+var SectorBuilderCorruptSectorCmd = &cobra.Command{
+	Use:   "corrupt-sector <sector-id>",
+	Short: "Deliberately damage the replica of a sealed sector for fault testing",
+	Long:  "Deliberately damage the replica of a sealed sector for fault testing. The sector builder does not expose sealed replica paths, so the replica file in the sealed directory must be given by --replica.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
 
-	sb, err := sectorbuilder.NewRustSectorBuilder(sectorbuilder.RustSectorBuilderConfig{
-		BlockService:     blockService, // not used, so just memory repo
-		LastUsedSectorID: lastUsedSectorID,
-		MetadataDir:      filepath.Join(getFilutilDir(), "metadata"),
-		MinerAddr:        minerAddr,
-		SealedSectorDir:  sealedDir,
-		SectorClass:      sectorClass,
-		StagedSectorDir:  stagingDir,
-	})
-	if err != nil {
-		panic(err)
-	}
+		sectorID, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return
+		}
+
+		ds, err := meta.Open(getFilutilDir())
+		if err != nil {
+			return
+		}
+		defer ds.Close()
 
-	max := types.NewBytesAmount(go_sectorbuilder.GetMaxUserBytesPerStagedSector(sectorClass.SectorSize().Uint64()))
+		has, err := ds.Has(meta.MakeKey(meta.SealedSectorMetadataPrefix, fmt.Sprint(sectorID)))
+		if err != nil {
+			return
+		}
+		if !has {
+			err = fmt.Errorf("sealed sector %d not found", sectorID)
+			return
+		}
 
-	return &SectorBuilder{
-		SectorBuilder:     sb,
-		MetaStore:         ds,
-		MaxBytesPerSector: max,
-	}
+		err = corruptReplica(corruptReplicaPath, corruptMode, corruptOffset, corruptTruncateTo)
+	},
 }
 
-var SectorBuilderSealSectorsCmd = &cobra.Command{
-	Use:   "seal-sectors",
-	Short: "Seal all staged sectors",
+var SectorBuilderExportSectorsCmd = &cobra.Command{
+	Use:   "export-sectors",
+	Short: "Export sealed sector metadata into a bundle",
+	Long:  "Export sealed sector metadata into a bundle, which can be imported by import-sectors to verify PoRep elsewhere. The sector builder does not expose sealed replica files, so they are not exported; use simple-sector-builder to export replicas for PoSt.",
 	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSectorBuilder()
-		defer sb.Close()
-
-		sb.SealAllStagedUnsealedSectors()
+		exitOnError(exportBundle(SectorBuilderCmd.Use, exportOutput, uintsToUint64s(exportSectors), false))
 	},
 }
 
-type SealedSectorMetadataOrder struct {
+var SectorBuilderImportSectorsCmd = &cobra.Command{
+	Use:   "import-sectors <bundle>",
+	Short: "Import sealed sector metadata from a bundle",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(importBundle(SectorBuilderCmd.Use, args[0], importForce))
+	},
 }
 
-func (o SealedSectorMetadataOrder) Compare(a, b query.Entry) int {
-	aID, err := strconv.ParseUint(strings.TrimLeft(a.Key, metaSectorBuilderSealedSectorMetadataPrefix+"/"), 0, 64)
-	if err != nil {
-		panic(err)
-	}
-	bID, err := strconv.ParseUint(strings.TrimLeft(a.Key, metaSectorBuilderSealedSectorMetadataPrefix+"/"), 0, 64)
-	if err != nil {
-		panic(err)
-	}
-	if aID < bID {
-		return -1
-	} else if aID > bID {
-		return 1
-	} else {
-		return 0
-	}
-}
+var SectorBuilderUnsealCmd = &cobra.Command{
+	Use:   "unseal <sector-id>",
+	Short: "Unseal piece from sealed sector and compare it with the pieces DAG copy",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
 
-func getSealedSectorMetadataList(metaStore *Datastore) []*sectorbuilder.SealedSectorMetadata {
-	queryResult, err := metaStore.Query(query.Query{
-		Prefix: metaSectorBuilderSealedSectorMetadataPrefix,
-		Orders: []query.Order{SealedSectorMetadataOrder{}},
-	})
-	if err != nil {
-		panic(err)
-	}
-	var result []*sectorbuilder.SealedSectorMetadata
-	for entry := range queryResult.Next() {
-		err = entry.Error
+		sectorID, err := strconv.ParseUint(args[0], 0, 64)
 		if err != nil {
-			panic(err)
+			return
 		}
 
-		var m sectorbuilder.SealedSectorMetadata
-		err = cbor.DecodeInto(entry.Value, &m)
+		sb, err := sbbackend.OpenSectorBuilder(builderConfig())
 		if err != nil {
-			panic(err)
+			return
 		}
-		result = append(result, &m)
-	}
-	return result
-}
-
-var SectorBuilderVerifySectorsPorepCmd = &cobra.Command{
-	Use:   "verify-sectors-porep",
-	Short: "Verify PoRep (Proof-of-Replication) of all sealed sectors",
-	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSectorBuilder()
 		defer sb.Close()
 
-		allSealed := getSealedSectorMetadataList(sb.MetaStore)
-		var sectorIDs []string
-		for _, s := range allSealed {
-			sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
-		}
-		fmt.Printf("All sealed sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
-		for _, s := range allSealed {
-			fmt.Printf("Verify sector %d: ", s.SectorID)
-			t := time.Now()
-			res, err := (&verification.RustVerifier{}).VerifySeal(verification.VerifySealRequest{
-				CommD:      s.CommD,
-				CommR:      s.CommR,
-				CommRStar:  s.CommRStar,
-				Proof:      s.Proof,
-				ProverID:   sectorbuilder.AddressToProverID(minerAddr),
-				SectorID:   s.SectorID,
-				SectorSize: types.TwoHundredFiftySixMiBSectorSize,
-			})
-			if err != nil {
-				fmt.Printf("error %s", red(err))
-			} else if !res.IsValid {
-				fmt.Print(red("invalid"))
-			} else {
-				fmt.Print("valid")
+		var sector *sectorbuilder.SealedSectorMetadata
+		for _, s := range sbbackend.SealedSectors(sb.MetaStore) {
+			if s.SectorID == sectorID {
+				sector = s
+				break
 			}
-			fmt.Printf(", took %v\n", time.Since(t))
 		}
-	},
-}
+		if sector == nil {
+			err = fmt.Errorf("sealed sector %d not found", sectorID)
+			return
+		}
 
-var SectorBuilderLsSectorsCmd = &cobra.Command{
-	Use:   "ls-sectors",
-	Short: "List all sectors",
-	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSectorBuilder()
-		defer sb.Close()
+		var pieceRefs []cid.Cid
+		for _, p := range sector.Pieces {
+			pieceRefs = append(pieceRefs, p.Ref)
+		}
+		pieceRef, err := selectSectorPiece(sectorID, pieceRefs, unsealPiece)
+		if err != nil {
+			return
+		}
 
-		fmt.Println(green("Staged sectors:"))
-		allStaged, err := sb.GetAllStagedSectors()
+		t := time.Now()
+		r, err := sb.ReadPieceFromSealedSector(pieceRef)
 		if err != nil {
-			panic(err)
+			return
 		}
-		for _, s := range allStaged {
-			fmt.Printf("  Sector %d\n", s.SectorID)
+		err = saveUnsealedPiece(r, pieceRef, unsealOutput)
+		if err != nil {
+			return
 		}
+		fmt.Printf("Unsealed piece %s from sector %d, took %v\n", pieceRef, sectorID, time.Since(t))
+	},
+}
 
-		fmt.Println(green("Sealed sectors:"))
-		allSealed := getSealedSectorMetadataList(sb.MetaStore)
-		for _, s := range allSealed {
-			fmt.Printf("  Sector %d\n", s.SectorID)
-			for _, p := range s.Pieces {
-				fmt.Printf("    Piece %s, size %d\n", cyan(p.Ref), p.Size)
-			}
+// selectSectorPiece picks the piece to unseal from the pieces of a sealed
+// sector. The piece may be omitted if the sector holds only one piece.
+func selectSectorPiece(sectorID uint64, pieceRefs []cid.Cid, piece string) (cid.Cid, error) {
+	if piece == "" {
+		if len(pieceRefs) != 1 {
+			return cid.Undef, fmt.Errorf("sector %d holds %d pieces, specify one with --piece", sectorID, len(pieceRefs))
 		}
-	},
+		return pieceRefs[0], nil
+	}
+
+	c, err := cid.Parse(piece)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, ref := range pieceRefs {
+		if ref.Equals(c) {
+			return c, nil
+		}
+	}
+	return cid.Undef, fmt.Errorf("piece %s not found in sector %d", c, sectorID)
 }
 
-var SectorBuilderVerifySectorsPostCmd = &cobra.Command{
-	Use:   "verify-sectors-post",
-	Short: "Challenge and verify PoSt (Proof-of-Spacetime) of all sealed sectors",
-	Run: func(cmd *cobra.Command, args []string) {
-		var challengeSeed types.PoStChallengeSeed
-		_, err := io.ReadFull(rand.Reader, challengeSeed[:])
+// saveUnsealedPiece writes unsealed piece data into filename, and compares it
+// with the copy in the pieces DAG if there is one. The data is written into a
+// temp file renamed to filename only if it matches, so a mismatch leaves no
+// partial file behind.
+func saveUnsealedPiece(r io.Reader, pieceRef cid.Cid, filename string) (err error) {
+	if filename == "" {
+		filename = pieceRef.String()
+	}
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err == nil {
+			err = os.Rename(tmp, filename)
+		}
 		if err != nil {
-			panic(err)
+			_ = os.Remove(tmp)
 		}
-		fmt.Printf("Use challenge seed: %s\n", hex.EncodeToString(challengeSeed[:]))
+	}()
 
-		sb := openSectorBuilder()
-		defer sb.Close()
+	dag, err := openSectorBuilderPiecesDAG()
+	if err != nil {
+		return
+	}
+	defer dag.Close()
 
-		allSealed := getSealedSectorMetadataList(sb.MetaStore)
-		var sectorInfos []go_sectorbuilder.SectorInfo
-		var sectorIDs []string
-		for _, s := range allSealed {
-			sectorInfos = append(sectorInfos, go_sectorbuilder.SectorInfo{
-				SectorID: s.SectorID,
-				CommR:    s.CommR,
-			})
-			sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
+	original, _, err := dag.OpenPiece(pieceRef)
+	if err == format.ErrNotFound {
+		_, err = io.Copy(f, r)
+		if err == nil {
+			fmt.Printf("Piece %s has no pieces DAG copy, saved into %s without comparison\n", cyan(pieceRef), filename)
 		}
-		sortedSectorInfo := go_sectorbuilder.NewSortedSectorInfo(sectorInfos...)
+		return err
+	} else if err != nil {
+		return err
+	}
+	defer original.Close()
 
-		fmt.Println("Generate PoSt ...")
-		t := time.Now()
-		gres, err := sb.GeneratePoSt(sectorbuilder.GeneratePoStRequest{
-			SortedSectorInfo: sortedSectorInfo,
-			ChallengeSeed:    challengeSeed,
-		})
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("  sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
-		fmt.Printf("  proof %s, took %v\n", hex.EncodeToString(gres.Proof), time.Since(t))
-
-		fmt.Println("Verify PoSt ...")
-		t = time.Now()
-		vres, err := (&verification.RustVerifier{}).VerifyPoSt(verification.VerifyPoStRequest{
-			ChallengeSeed:    challengeSeed,
-			SortedSectorInfo: sortedSectorInfo,
-			Faults:           []uint64{},
-			Proof:            gres.Proof,
-			SectorSize:       types.TwoHundredFiftySixMiBSectorSize,
-		})
-		if err != nil {
-			panic(err)
+	offset, err := comparePieceData(io.TeeReader(r, f), original)
+	if err != nil {
+		return err
+	}
+	if offset >= 0 {
+		return fmt.Errorf("unsealed piece %s differs from its pieces DAG copy at offset %d, not saved", pieceRef, offset)
+	}
+	fmt.Printf("Piece %s saved into %s, %s its pieces DAG copy\n", cyan(pieceRef), filename, green("matches"))
+	return nil
+}
+
+// comparePieceData returns the offset of the first byte where a and b differ,
+// or -1 if they are equal.
+func comparePieceData(a, b io.Reader) (int64, error) {
+	bufA := make([]byte, 1<<20)
+	bufB := make([]byte, 1<<20)
+	var offset int64
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, errA
+		}
+		nB, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, errB
+		}
+		n := nA
+		if nB < n {
+			n = nB
+		}
+		for i := 0; i < n; i++ {
+			if bufA[i] != bufB[i] {
+				return offset + int64(i), nil
+			}
 		}
-		if !vres.IsValid {
-			fmt.Print(red("  invalid"))
-		} else {
-			fmt.Print("  valid")
+		if nA != nB {
+			return offset + int64(n), nil
+		}
+		if errA != nil {
+			return -1, nil
 		}
-		fmt.Printf(", took %v\n", time.Since(t))
+		offset += int64(n)
+	}
+}
+
+var SectorBuilderVerifySectorsPostCmd = &cobra.Command{
+	Use:   "verify-sectors-post",
+	Short: "Challenge and verify PoSt (Proof-of-Spacetime) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(verifySectorsPoSt(SectorBuilderCmd.Use))
 	},
 }
@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder/multisectorbuilder"
+	"github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+
+	sbbackend "github.com/filcloud/filutil/backend/sectorbuilder"
+	"github.com/filcloud/filutil/backend/simple"
+	"github.com/filcloud/filutil/meta"
+)
+
+// bundleStore is the sealed sector metadata of a sector builder in the meta
+// datastore, which export-sectors and import-sectors move in bundles.
+type bundleStore interface {
+	// sealedSectorIDs returns the IDs of all sealed sectors in ascending
+	// order.
+	sealedSectorIDs() ([]uint64, error)
+	// exportSector returns the encoded metadata of the sealed sector, and the
+	// path of its replica, or "" if the sector builder does not expose it.
+	exportSector(sectorID uint64) (metadata []byte, replica string, err error)
+	hasSector(sectorID uint64) (bool, error)
+	// importSector saves the sealed sector of the bundle. replica is the
+	// replica file of the sector extracted from the bundle, if any, which is
+	// moved into place.
+	importSector(bs BundleSector, replica string) error
+	// replicaDir is the directory to extract replicas into, or "" if the
+	// sector builder does not take replicas.
+	replicaDir() string
+}
+
+func openBundleStore(builder string, ds *meta.Datastore) (bundleStore, error) {
+	switch builder {
+	case SectorBuilderCmd.Use:
+		return &sectorBuilderBundleStore{ds: ds}, nil
+	case SimpleSectorBuilderCmd.Use:
+		return &simpleBundleStore{
+			sectorManager: simple.OpenSectorStateManager(ds, minerAddr),
+			sealedDir:     filepath.Join(getFilutilDir(), "sealed"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s does not support sector bundles", builder)
+	}
+}
+
+// exportBundle runs export-sectors of the builder, exporting the sealed
+// sectors, or all sealed sectors if sectorIDs is empty, into output.
+func exportBundle(builder, output string, sectorIDs []uint64, withReplicas bool) error {
+	ds, err := meta.Open(getFilutilDir())
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	store, err := openBundleStore(builder, ds)
+	if err != nil {
+		return err
+	}
+	if withReplicas && store.replicaDir() == "" {
+		return fmt.Errorf("%s does not expose sealed replica files", builder)
+	}
+
+	if len(sectorIDs) == 0 {
+		sectorIDs, err = store.sealedSectorIDs()
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := createBundle(output)
+	if err != nil {
+		return err
+	}
+	manifest := &BundleManifest{
+		Builder:    builder,
+		SectorSize: sectorSize.Uint64(),
+	}
+	for _, id := range sectorIDs {
+		bs := BundleSector{SectorID: id}
+		var replica string
+		bs.Metadata, replica, err = store.exportSector(id)
+		if err != nil {
+			return err
+		}
+		if withReplicas {
+			bs.Replica, err = w.AddReplica(replica)
+			if err != nil {
+				return err
+			}
+		}
+		manifest.Sectors = append(manifest.Sectors, bs)
+	}
+	err = w.Close(manifest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d sealed sectors into %s\n", len(manifest.Sectors), output)
+	return nil
+}
+
+// importBundle runs import-sectors of the builder. Sealed sectors which
+// already exist are skipped, unless force.
+func importBundle(builder, bundle string, force bool) error {
+	ds, err := meta.Open(getFilutilDir())
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	store, err := openBundleStore(builder, ds)
+	if err != nil {
+		return err
+	}
+
+	if dir := store.replicaDir(); dir != "" {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	manifest, replicas, err := readBundle(bundle, store.replicaDir())
+	if err != nil {
+		return err
+	}
+	defer removeBundleReplicas(replicas)
+	err = checkBundle(manifest, builder)
+	if err != nil {
+		return err
+	}
+
+	var imported int
+	for _, bs := range manifest.Sectors {
+		has, err := store.hasSector(bs.SectorID)
+		if err != nil {
+			return err
+		}
+		if has && !force {
+			fmt.Printf("Skip sector %d, which already exists\n", bs.SectorID)
+			continue
+		}
+
+		var replica string
+		if bs.Replica != nil {
+			replica = replicas[bs.Replica.Name]
+		}
+		err = store.importSector(bs, replica)
+		if err != nil {
+			return err
+		}
+		if replica != "" {
+			delete(replicas, bs.Replica.Name)
+		}
+		err = meta.SetSectorState(ds, bs.SectorID, meta.SectorSealed, nil)
+		if err != nil {
+			return err
+		}
+		imported++
+		switch {
+		case replica != "":
+			fmt.Printf("Imported sector %d with replica %s\n", bs.SectorID, bs.Replica.Name)
+		case store.replicaDir() != "":
+			fmt.Printf("Imported sector %d without replica, PoSt is not possible for it\n", bs.SectorID)
+		default:
+			fmt.Printf("Imported sector %d\n", bs.SectorID)
+		}
+	}
+	fmt.Printf("Imported %d of %d sealed sectors from %s\n", imported, len(manifest.Sectors), bundle)
+	return nil
+}
+
+// checkBundle checks the bundle was exported by the same kind of sector
+// builder with the same sector size.
+func checkBundle(manifest *BundleManifest, builder string) error {
+	if manifest.Builder != builder {
+		return fmt.Errorf("bundle was exported by %s, import it with %s", manifest.Builder, manifest.Builder)
+	}
+	if manifest.SectorSize != sectorSize.Uint64() {
+		return fmt.Errorf("bundle sector size %d mismatches %d", manifest.SectorSize, sectorSize.Uint64())
+	}
+	return nil
+}
+
+// sectorBuilderBundleStore keeps the CBOR encoded sealed sector metadata of
+// the sector builder, which does not expose sealed replica files.
+type sectorBuilderBundleStore struct {
+	ds *meta.Datastore
+}
+
+func (s *sectorBuilderBundleStore) sealedSectorIDs() ([]uint64, error) {
+	var ids []uint64
+	for _, m := range sbbackend.SealedSectors(s.ds) {
+		ids = append(ids, m.SectorID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *sectorBuilderBundleStore) key(sectorID uint64) datastore.Key {
+	return meta.MakeKey(meta.SealedSectorMetadataPrefix, fmt.Sprint(sectorID))
+}
+
+func (s *sectorBuilderBundleStore) exportSector(sectorID uint64) ([]byte, string, error) {
+	metadata, err := s.ds.Get(s.key(sectorID))
+	if err == datastore.ErrNotFound {
+		return nil, "", fmt.Errorf("sealed sector %d not found", sectorID)
+	}
+	return metadata, "", err
+}
+
+func (s *sectorBuilderBundleStore) hasSector(sectorID uint64) (bool, error) {
+	return s.ds.Has(s.key(sectorID))
+}
+
+func (s *sectorBuilderBundleStore) importSector(bs BundleSector, replica string) error {
+	var m sectorbuilder.SealedSectorMetadata
+	err := cbor.DecodeInto(bs.Metadata, &m)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode metadata of sector %d", bs.SectorID)
+	}
+	if m.SectorID != bs.SectorID {
+		return fmt.Errorf("metadata of sector %d is of sector %d", bs.SectorID, m.SectorID)
+	}
+	err = s.ds.Put(s.key(m.SectorID), bs.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// Keep the sector builder from reusing imported sector IDs.
+	var lastUsedSectorID uint64
+	v, err := s.ds.Get(datastore.NewKey(meta.LastUsedSectorIDPrefix))
+	if err == nil {
+		lastUsedSectorID, err = strconv.ParseUint(string(v), 0, 64)
+		if err != nil {
+			return err
+		}
+	} else if err != datastore.ErrNotFound {
+		return err
+	}
+	if m.SectorID <= lastUsedSectorID {
+		return nil
+	}
+	return s.ds.Put(datastore.NewKey(meta.LastUsedSectorIDPrefix), []byte(fmt.Sprint(m.SectorID)))
+}
+
+func (s *sectorBuilderBundleStore) replicaDir() string {
+	return ""
+}
+
+// simpleBundleStore keeps the JSON encoded sealed sector metadata of the
+// simple sector builder, with replica files in the sealed directory.
+type simpleBundleStore struct {
+	sectorManager *multisectorbuilder.SectorStateManager
+	sealedDir     string
+}
+
+func (s *simpleBundleStore) sealedSectorIDs() ([]uint64, error) {
+	sealedMap, _ := s.sectorManager.GetSealed(minerAddr) // ignore error
+	var ids []uint64
+	for id := range sealedMap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *simpleBundleStore) exportSector(sectorID uint64) ([]byte, string, error) {
+	sealedMap, _ := s.sectorManager.GetSealed(minerAddr) // ignore error
+	m, ok := sealedMap[sectorID]
+	if !ok {
+		return nil, "", fmt.Errorf("sealed sector %d not found", sectorID)
+	}
+	replica := simple.SectorAccessPath(s.sealedDir, m.SectorAccess)
+	// The sector access path is local to this machine, so only its file name
+	// is exported.
+	m.SectorAccess = filepath.Base(m.SectorAccess)
+	metadata, err := json.Marshal(m)
+	return metadata, replica, err
+}
+
+func (s *simpleBundleStore) hasSector(sectorID uint64) (bool, error) {
+	sealedMap, _ := s.sectorManager.GetSealed(minerAddr) // ignore error
+	_, ok := sealedMap[sectorID]
+	return ok, nil
+}
+
+func (s *simpleBundleStore) importSector(bs BundleSector, replica string) error {
+	var m multisectorbuilder.SealedSectorMetadata
+	err := json.Unmarshal(bs.Metadata, &m)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode metadata of sector %d", bs.SectorID)
+	}
+	if m.SectorID != bs.SectorID {
+		return fmt.Errorf("metadata of sector %d is of sector %d", bs.SectorID, m.SectorID)
+	}
+
+	// The sector access is from the bundle, so it must not name a file
+	// outside the sealed directory.
+	err = checkBundleFileName(m.SectorAccess)
+	if err != nil {
+		return errors.Wrapf(err, "invalid sector access of sector %d", m.SectorID)
+	}
+	m.SectorAccess = filepath.Join(s.sealedDir, m.SectorAccess)
+	if replica != "" {
+		m.SectorAccess = filepath.Join(s.sealedDir, bs.Replica.Name)
+		err = os.Rename(replica, m.SectorAccess)
+		if err != nil {
+			return err
+		}
+	}
+	return s.sectorManager.PutSealed(minerAddr, m)
+}
+
+func (s *simpleBundleStore) replicaDir() string {
+	return s.sealedDir
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/meta"
+)
+
+// SectorInfo is the detailed view of a staged or sealed sector.
+type SectorInfo struct {
+	SectorID uint64
+	State    *meta.SectorState
+
+	Staged     bool
+	StagedPath string
+
+	Sealed     bool
+	SealedPath string
+	CommD      [32]byte
+	CommR      [32]byte
+	CommRStar  [32]byte
+	Proof      []byte
+
+	// PiecesKnown tells whether Pieces is available, since the sector builder
+	// does not expose pieces of staged sectors.
+	PiecesKnown       bool
+	Pieces            []SectorPieceInfo
+	MaxBytesPerSector uint64
+}
+
+type SectorPieceInfo struct {
+	Ref  string
+	Size uint64
+}
+
+// minimumPieceBytes is the unpadded size of the smallest piece the sector
+// builder lays out in a sector.
+const minimumPieceBytes = 4*32 - 1
+
+// pieceAlignment returns the left and right padding bytes the sector builder
+// puts around a piece written after writtenBytes bytes of a sector. Pieces are
+// aligned to the unpadded size of the next power-of-two sized piece.
+func pieceAlignment(writtenBytes, pieceBytes uint64) (left, right uint64) {
+	adjusted := pieceBytes
+	if adjusted < minimumPieceBytes {
+		adjusted = minimumPieceBytes
+	}
+	needed := uint64(minimumPieceBytes)
+	for needed < adjusted {
+		needed *= 2
+	}
+	if encroaching := writtenBytes % needed; encroaching > 0 {
+		left = needed - encroaching
+	}
+	right = needed - pieceBytes
+	return left, right
+}
+
+func printSectorInfo(info *SectorInfo) {
+	fmt.Printf("Sector %d: %s\n", info.SectorID, formatSectorState(info.State))
+
+	if info.Staged {
+		fmt.Printf("  %s %s\n", green("Staged file:"), formatSectorFile(info.StagedPath))
+	}
+	if info.Sealed {
+		fmt.Printf("  %s %s\n", green("Sealed file:"), formatSectorFile(info.SealedPath))
+		fmt.Printf("  CommD:     %s\n", hex.EncodeToString(info.CommD[:]))
+		fmt.Printf("  CommR:     %s\n", hex.EncodeToString(info.CommR[:]))
+		fmt.Printf("  CommRStar: %s\n", hex.EncodeToString(info.CommRStar[:]))
+		fmt.Printf("  Proof:     %d bytes, %s\n", len(info.Proof), hex.EncodeToString(info.Proof))
+	}
+
+	if !info.PiecesKnown {
+		fmt.Println("  Pieces: not exposed by the sector builder for staged sectors")
+	} else {
+		var written, filled uint64
+		fmt.Printf("  Pieces: %d\n", len(info.Pieces))
+		for _, p := range info.Pieces {
+			left, right := pieceAlignment(written, p.Size)
+			offset := written + left
+			written = offset + p.Size + right
+			filled += p.Size
+			fmt.Printf("    Piece %s, offset %d, size %d\n", cyan(p.Ref), offset, p.Size)
+		}
+		fmt.Printf("  Fill: %s of %s pieces (%.1f%%), %s with alignment\n",
+			backend.FormatBytes(filled), backend.FormatBytes(info.MaxBytesPerSector),
+			100*float64(filled)/float64(info.MaxBytesPerSector), backend.FormatBytes(written))
+	}
+
+	if info.State != nil {
+		sealing, ok1 := info.State.EnteredAt[meta.SectorSealing]
+		sealed, ok2 := info.State.EnteredAt[meta.SectorSealed]
+		if ok1 && ok2 && sealed.After(sealing) {
+			fmt.Printf("  Sealing took %v\n", sealed.Sub(sealing))
+		}
+		if v := info.State.LastVerification; v != nil {
+			var outcome string
+			if v.Error != "" {
+				outcome = fmt.Sprintf("error %s", red(v.Error))
+			} else if v.Valid {
+				outcome = green("valid")
+			} else {
+				outcome = red("invalid")
+			}
+			fmt.Printf("  Last verification: %s at %s\n", outcome, v.At.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Println("  Last verification: never")
+		}
+	}
+}
+
+func formatSectorFile(path string) string {
+	if path == "" {
+		return "unknown, not exposed by the sector builder"
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("%s, %s", path, red(err))
+	}
+	return fmt.Sprintf("%s, %s on disk", path, backend.FormatBytes(uint64(stat.Size())))
+}
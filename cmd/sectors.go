@@ -0,0 +1,503 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/backend"
+	_ "github.com/filcloud/filutil/backend/mock" // register the mock backend
+	"github.com/filcloud/filutil/meta"
+)
+
+var sectorsBackend string
+var sectorsNoSeal bool
+var sectorsMaxParallel int
+
+func init() {
+	rootCmd.AddCommand(SectorsCmd)
+
+	SectorsCmd.AddCommand(SectorsAddPieceCmd)
+	SectorsCmd.AddCommand(SectorsSealCmd)
+	SectorsCmd.AddCommand(SectorsLsCmd)
+	SectorsCmd.AddCommand(SectorsCheckCmd)
+	SectorsCmd.AddCommand(SectorsVerifyPoRepCmd)
+	SectorsCmd.AddCommand(SectorsVerifyPoStCmd)
+
+	SectorsCmd.PersistentFlags().StringVar(&sectorsBackend, "backend", SectorBuilderCmd.Use, "The sector builder backend, sector-builder, simple-sector-builder or mock")
+	SectorsCmd.PersistentFlags().IntVar(&sectorsMaxParallel, "max-parallel", 1, "The max number of sectors sealed in parallel, if the backend supports it")
+	SectorsAddPieceCmd.Flags().BoolVar(&sectorsNoSeal, "no-seal", false, "Add piece without sealing staged sectors")
+	SectorsSealCmd.Flags().UintSliceVar(&sealSectors, "sector", nil, "The staged sectors to seal, defaults to all unsealed staged sectors")
+	SectorsCheckCmd.Flags().BoolVar(&checkSectorsSample, "sample", false, "Sample Merkle challenges of each sector against its CommR by a single sector PoSt")
+	addImportFlags(SectorsAddPieceCmd)
+	addPoStFlags(SectorsVerifyPoStCmd)
+}
+
+var SectorsCmd = &cobra.Command{
+	Use:   "sectors",
+	Short: "Sector workflow on the sector builder backend selected by --backend",
+}
+
+// metaBackend is implemented by the sector builder backends, whose sector
+// builders keep sector states and piece records in the meta datastore.
+type metaBackend interface {
+	MetaStore() *meta.Datastore
+}
+
+// metaStoreOf returns the meta datastore the backend keeps open, or nil if it
+// does not keep one.
+func metaStoreOf(b backend.SectorBuilder) *meta.Datastore {
+	if m, ok := b.(metaBackend); ok {
+		return m.MetaStore()
+	}
+	return nil
+}
+
+// optionSealer is implemented by the sector builder backends, which schedule
+// sealing by all of backend.SealOptions.
+type optionSealer interface {
+	SealWithOptions(opts *backend.SealOptions) error
+}
+
+// replicaBackend is implemented by the backends which expose the sealed
+// replica files.
+type replicaBackend interface {
+	ReplicaPath(sectorID uint64) (string, bool)
+}
+
+// builderConfig returns the backend config of the filutil directory with the
+// configured sector size and miner.
+func builderConfig() backend.Config {
+	return backend.Config{
+		Dir:        getFilutilDir(),
+		SectorSize: sectorSize.Uint64(),
+		MinerAddr:  minerAddr.String(),
+		Progress:   backendProgress,
+	}
+}
+
+// openBackend opens the sector builder of the named backend on the filutil
+// directory with the configured sector size.
+func openBackend(name string, maxParallel int) (*backend.Backend, backend.SectorBuilder, error) {
+	bk, err := backend.Get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := builderConfig()
+	cfg.MaxParallel = maxParallel
+	b, err := bk.Open(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bk, b, nil
+}
+
+func closeSectorsBackend(b backend.SectorBuilder) {
+	if err := b.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// openBackendMetaStore returns the meta datastore the backend keeps open, or
+// opens it for the backends which do not keep one. The returned release
+// closes only what was opened here.
+func openBackendMetaStore(b backend.SectorBuilder) (ds *meta.Datastore, release func(), err error) {
+	if ds := metaStoreOf(b); ds != nil {
+		return ds, func() {}, nil
+	}
+	ds, err = meta.Open(getFilutilDir())
+	if err != nil {
+		return nil, nil, err
+	}
+	return ds, ds.Close, nil
+}
+
+// sealBackend seals staged sectors of the backend by opts. Backends other than
+// the sector builders, like mock, can only seal the selected sectors.
+func sealBackend(b backend.SectorBuilder, opts *backend.SealOptions) error {
+	if s, ok := b.(optionSealer); ok {
+		return s.SealWithOptions(opts)
+	}
+	if opts.Resume || opts.RetryFailed || opts.MinFill > 0 {
+		return errors.New("--resume, --retry-failed and --min-fill are not supported by the backend")
+	}
+	return b.Seal(context.Background(), opts.Sectors)
+}
+
+// addBackendPieces imports the file or directory into the pieces DAG, adds
+// the pieces into staged sectors of the backend, and records the sector of
+// each piece. It returns the sector of each piece.
+func addBackendPieces(b backend.SectorBuilder, dag *DAG, ds *meta.Datastore, filename string, recursive, perFile bool, opts ImportOptions) ([]ImportedPiece, []uint64, error) {
+	start := time.Now()
+	pieces, err := importPieces(dag, ds, filename, recursive, perFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var size uint64
+	sectorIDs := make([]uint64, len(pieces))
+	fileAdder, _ := b.(backend.FileAdder)
+	for i, p := range pieces {
+		if path, ok := unchangedPieceFile(p, start); ok && fileAdder != nil {
+			sectorIDs[i], err = addSectorsPieceFile(fileAdder, p, path)
+		} else {
+			sectorIDs[i], err = addSectorsPiece(b, dag, p.Cid)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		err = setPieceSector(ds, p.Cid, sectorIDs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		size += p.Size
+	}
+	printThroughput(size, time.Since(start))
+	return pieces, sectorIDs, nil
+}
+
+// addSectorsPiece adds the piece c streamed from the pieces DAG into a staged
+// sector, so the sealed data always matches the CID and CommP recorded at
+// import, even if the imported file changed since.
+func addSectorsPiece(b backend.SectorBuilder, dag *DAG, c cid.Cid) (uint64, error) {
+	r, size, err := dag.OpenPiece(c)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	t := time.Now()
+	sectorID, err := b.AddPiece(context.Background(), c.String(), size, r)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("Added piece %s into staging sector %d, took %v\n", c, sectorID, time.Since(t))
+	return sectorID, nil
+}
+
+// unchangedPieceFile returns the file of the piece imported since start, if
+// the piece data is the whole file and the file has not changed since.
+func unchangedPieceFile(p ImportedPiece, start time.Time) (string, bool) {
+	if p.Dir {
+		return "", false
+	}
+	stat, err := os.Stat(p.Path)
+	if err != nil || !stat.Mode().IsRegular() || uint64(stat.Size()) != p.Size || stat.ModTime().After(start) {
+		return "", false
+	}
+	return p.Path, true
+}
+
+// addSectorsPieceFile adds the piece from the file it was just imported from,
+// which the sector builder reads directly rather than a copy from the pieces
+// DAG.
+func addSectorsPieceFile(b backend.FileAdder, p ImportedPiece, path string) (uint64, error) {
+	t := time.Now()
+	sectorID, err := b.AddPieceFile(context.Background(), p.Cid.String(), p.Size, path)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("Added piece %s into staging sector %d, took %v\n", p.Cid, sectorID, time.Since(t))
+	return sectorID, nil
+}
+
+// addPieces runs add-piece on the named backend.
+func addPieces(name, filename string, noSeal bool, maxParallel int) error {
+	_, b, err := openBackend(name, maxParallel)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+	dag, err := openSectorBuilderPiecesDAG()
+	if err != nil {
+		return err
+	}
+	defer dag.Close()
+	ds, release, err := openBackendMetaStore(b)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, _, err = addBackendPieces(b, dag, ds, filename, pieceRecursive, piecePerFile, pieceImportOptions)
+	if err != nil {
+		return err
+	}
+	if noSeal {
+		return nil
+	}
+	return sealBackend(b, &backend.SealOptions{MaxParallel: maxParallel, Order: backend.SealOrderOldest})
+}
+
+// generatePieces runs generate-piece on the named backend, generating n
+// pieces by the flags of cmd.
+func generatePieces(cmd *cobra.Command, name string, n int, noSeal bool) error {
+	bk, b, err := openBackend(name, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+	ds, release, err := openBackendMetaStore(b)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	gen, err := newPieceGenerator(cmd, bk.MaxBytesPerSector(sectorSize.Uint64()))
+	if err != nil {
+		return err
+	}
+	var dag *DAG
+	if genPieceDAG {
+		dag, err = openSectorBuilderPiecesDAG()
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+	}
+
+	for i := 0; i < n; i++ {
+		pieceData, err := gen.Next()
+		if err != nil {
+			return err
+		}
+		c, err := generatedPieceCid(dag, ds, pieceData)
+		if err != nil {
+			return err
+		}
+
+		t := time.Now()
+		sectorID, err := b.AddPiece(context.Background(), c.String(), uint64(len(pieceData)), bytes.NewReader(pieceData))
+		if err != nil {
+			return err
+		}
+		err = setPieceSector(ds, c, sectorID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generate and add piece %s with size %d into staging sector %d, took %v\n", c, len(pieceData), sectorID, time.Since(t))
+	}
+
+	if noSeal {
+		return nil
+	}
+	return sealBackend(b, &backend.SealOptions{MaxParallel: 1, Order: backend.SealOrderOldest})
+}
+
+// sealStagedSectors runs seal-sectors on the named backend.
+func sealStagedSectors(name string, opts *backend.SealOptions) error {
+	_, b, err := openBackend(name, opts.MaxParallel)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+	return sealBackend(b, opts)
+}
+
+// lsSectors runs ls-sectors on the named backend. Sector states are shown
+// for the backends which keep them.
+func lsSectors(name string) error {
+	_, b, err := openBackend(name, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+
+	ds := metaStoreOf(b)
+	state := func(sectorID uint64) string {
+		if ds == nil {
+			return ""
+		}
+		return ", " + sectorStateOf(ds, sectorID)
+	}
+
+	staged, err := b.ListStaged()
+	if err != nil {
+		return err
+	}
+	fmt.Println(green("Staged sectors:"))
+	for _, s := range staged {
+		fmt.Printf("  Sector %d%s\n", s.SectorID, state(s.SectorID))
+		if !s.PiecesKnown {
+			fmt.Println("    Pieces: not exposed by the backend")
+		}
+		for _, p := range s.Pieces {
+			fmt.Printf("    Piece %s, size %d\n", cyan(p.Ref), p.Size)
+		}
+	}
+
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return err
+	}
+	fmt.Println(green("Sealed sectors:"))
+	for _, s := range sealed {
+		fmt.Printf("  Sector %d%s, CommR %s\n", s.SectorID, state(s.SectorID), hex.EncodeToString(s.CommR[:]))
+		for _, p := range s.Pieces {
+			fmt.Printf("    Piece %s, size %d\n", cyan(p.Ref), p.Size)
+		}
+	}
+	return nil
+}
+
+// verifyBackendPoRep verifies PoRep of all sealed sectors of the backend, and
+// records the results for the backends which keep sector states. It returns
+// the number of sectors which failed verification.
+func verifyBackendPoRep(bk *backend.Backend, b backend.SectorBuilder) (failed int, err error) {
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return 0, err
+	}
+	var sectorIDs []string
+	for _, s := range sealed {
+		sectorIDs = append(sectorIDs, fmt.Sprint(s.SectorID))
+	}
+	fmt.Printf("All sealed sectors: [%s]\n", blue(strings.Join(sectorIDs, ", ")))
+
+	ds := metaStoreOf(b)
+	for _, s := range sealed {
+		fmt.Printf("Verify sector %d: ", s.SectorID)
+		t := time.Now()
+		valid, verifyErr := bk.Verifier.VerifySeal(sectorSize.Uint64(), s)
+		if verifyErr != nil {
+			fmt.Printf("error %s", red(verifyErr))
+			failed++
+		} else if !valid {
+			fmt.Print(red("invalid"))
+			failed++
+		} else {
+			fmt.Print("valid")
+		}
+		if ds != nil {
+			err = meta.RecordSectorVerification(ds, s.SectorID, verifyErr == nil && valid, verifyErr)
+			if err != nil {
+				return failed, err
+			}
+		}
+		fmt.Printf(", took %v\n", time.Since(t))
+	}
+	return failed, nil
+}
+
+// verifySectorsPoRep runs verify-sectors-porep on the named backend, and
+// fails if any sector failed verification.
+func verifySectorsPoRep(name string) error {
+	bk, b, err := openBackend(name, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+
+	failed, err := verifyBackendPoRep(bk, b)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d sealed sectors failed PoRep verification", failed)
+	}
+	return nil
+}
+
+// checkSectors runs check-sectors on the named backend. Replica files are
+// checked for the backends which expose them, and Merkle challenges are
+// sampled with sample or if they do not. postCmd is the verify PoSt command
+// to declare the faults by.
+func checkSectors(name string, sample bool, postCmd string) error {
+	bk, b, err := openBackend(name, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSectorsBackend(b)
+	replicas, _ := b.(replicaBackend)
+
+	sealed, err := b.ListSealed()
+	if err != nil {
+		return err
+	}
+	var faults []uint64
+	for _, s := range sealed {
+		fmt.Printf("Check sector %d\n", s.SectorID)
+		var checkErr error
+		var path string
+		var ok bool
+		if replicas != nil {
+			path, ok = replicas.ReplicaPath(s.SectorID)
+		}
+		if ok {
+			checkErr = checkReplica(path, sectorSize.Uint64())
+		}
+		if checkErr == nil && (sample || !ok) {
+			checkErr = sampleSector(b, bk.Verifier, s)
+		}
+		if printSectorCheck(s.SectorID, checkErr) {
+			faults = append(faults, s.SectorID)
+		}
+	}
+	printSectorFaults(postCmd, len(sealed), faults)
+	if len(faults) > 0 {
+		return fmt.Errorf("found %d faulty sectors", len(faults))
+	}
+	return nil
+}
+
+var SectorsAddPieceCmd = &cobra.Command{
+	Use:   "add-piece <file|dir>",
+	Short: "Add piece",
+	Long:  "Add a file as a piece. With --recursive, a directory tree is added as one UnixFS directory piece whose piece data is a tar archive of the tree, or as one piece per file with --per-file.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(addPieces(sectorsBackend, args[0], sectorsNoSeal, sectorsMaxParallel))
+	},
+}
+
+var SectorsSealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Seal staged sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(sealStagedSectors(sectorsBackend, &backend.SealOptions{
+			MaxParallel: sectorsMaxParallel,
+			Order:       backend.SealOrderOldest,
+			Sectors:     uintsToUint64s(sealSectors),
+		}))
+	},
+}
+
+var SectorsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List staged and sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(lsSectors(sectorsBackend))
+	},
+}
+
+var SectorsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check sealed sectors for faults",
+	Long:  "Check sealed sectors for faults: the replica file must exist with the sector size and be readable, if the backend exposes it, and Merkle challenges sampled from it must match CommR, with --sample or if it does not.",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(checkSectors(sectorsBackend, checkSectorsSample, "sectors --backend "+sectorsBackend+" verify-post"))
+	},
+}
+
+var SectorsVerifyPoRepCmd = &cobra.Command{
+	Use:   "verify-porep",
+	Short: "Verify PoRep (Proof-of-Replication) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(verifySectorsPoRep(sectorsBackend))
+	},
+}
+
+var SectorsVerifyPoStCmd = &cobra.Command{
+	Use:   "verify-post",
+	Short: "Challenge and verify PoSt (Proof-of-Spacetime) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(verifySectorsPoSt(sectorsBackend))
+	},
+}
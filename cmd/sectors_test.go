@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-filecoin/types"
+
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/backend/mock"
+	"github.com/filcloud/filutil/meta"
+)
+
+func TestSectorsMockBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filutil-sectors-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	utilDir := filepath.Join(dir, "filutil")
+	err = os.Mkdir(utilDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	for i, size := range []int{500, 400, 700} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(i))).Read(data)
+		file := filepath.Join(dir, fmt.Sprintf("piece%d", i))
+		err = ioutil.WriteFile(file, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	// The commands report errors by exiting, so their functions are run
+	// here with the flags they read set as on the command line.
+	defer func(dir string, size *types.BytesAmount) {
+		filutilDir, sectorSize = dir, size
+	}(filutilDir, sectorSize)
+	filutilDir, sectorSize = utilDir, types.NewBytesAmount(1024)
+
+	if err = initFilutilDir(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err = addPieces(mock.Name, file, true, 1); err != nil {
+			t.Fatalf("add-piece %s: %s", file, err)
+		}
+	}
+
+	b, err := backend.Open(mock.Name, backend.Config{Dir: utilDir, SectorSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := b.ListStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first two pieces fit one sector, the third needs another.
+	if len(staged) != 2 || len(staged[0].Pieces) != 2 || len(staged[1].Pieces) != 1 {
+		t.Fatalf("staged sectors %+v, expected sectors of two and one pieces", staged)
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sealStagedSectors(mock.Name, &backend.SealOptions{}); err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+	if err = lsSectors(mock.Name); err != nil {
+		t.Fatalf("ls: %s", err)
+	}
+	if err = verifySectorsPoRep(mock.Name); err != nil {
+		t.Fatalf("verify-porep: %s", err)
+	}
+	if err = verifySectorsPoSt(mock.Name); err != nil {
+		t.Fatalf("verify-post: %s", err)
+	}
+
+	b, err = backend.Open(mock.Name, backend.Config{Dir: utilDir, SectorSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	sealed, err := b.ListSealed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != 2 {
+		t.Fatalf("sealed sectors %+v, expected 2", sealed)
+	}
+
+	ds, err := meta.Open(getFilutilDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	records, err := getPieceRecordList(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d piece records, expected 3", len(records))
+	}
+	for _, r := range records {
+		if r.SectorID == nil {
+			t.Fatalf("piece %s has no sector recorded", r.Cid)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filcloud/filutil/meta"
+)
+
+// sectorStateOf formats the state of a sector for listing.
+func sectorStateOf(ds *meta.Datastore, sectorID uint64) string {
+	s, err := meta.GetSectorState(ds, sectorID)
+	if err != nil {
+		return red(err)
+	}
+	return formatSectorState(s)
+}
+
+func formatSectorState(s *meta.SectorState) string {
+	if s == nil {
+		return "unknown"
+	}
+	var state string
+	switch s.State {
+	case meta.SectorSealed, meta.SectorVerified:
+		state = green(s.State)
+	case meta.SectorFailed, meta.SectorInvalid:
+		state = red(s.State)
+	default:
+		state = yellow(s.State)
+	}
+	state += fmt.Sprintf(" since %s", s.UpdatedAt.Format(time.RFC3339))
+	if s.Error != "" {
+		state += fmt.Sprintf(", error %s", red(s.Error))
+	}
+	return state
+}
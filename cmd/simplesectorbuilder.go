@@ -1,35 +1,26 @@
 package cmd
 
 import (
-	"bytes"
-	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
-	"unsafe"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-merkledag"
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/filecoin-project/go-filecoin/address"
-	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
-	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder/multisectorbuilder"
-	"github.com/filecoin-project/go-filecoin/proofs/verification"
-	"github.com/filecoin-project/go-filecoin/types"
-	go_sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
-	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+	"github.com/filcloud/filutil/backend"
+	"github.com/filcloud/filutil/backend/simple"
+	"github.com/filcloud/filutil/meta"
 )
 
-var simplePieceNum int
+var simpleUnsealPiece string
+var simpleUnsealOutput string
+var sealMaxParallel int
+var sealOrder string
+var sealMemoryPerSector uint64
+var exportReplicas bool
 
 func init() {
 	rootCmd.AddCommand(SimpleSectorBuilderCmd)
@@ -38,16 +29,42 @@ func init() {
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderGenPieceCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderLsPiecesCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderGetPiecesCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderCommPCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderSealSectorsCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderLsSectorsCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderSectorInfoCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderExportSectorsCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderImportSectorsCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderCorruptSectorCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderCheckSectorsCmd)
+	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderUnsealCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderVerifySectorsPorepCmd)
 	SimpleSectorBuilderCmd.AddCommand(SimpleSectorBuilderVerifySectorsPostCmd)
 
-	SimpleSectorBuilderGenPieceCmd.Flags().IntVarP(&simplePieceNum, "piece-num", "n", 1, "The number of pieces to generate")
+	SimpleSectorBuilderGenPieceCmd.Flags().IntVarP(&pieceNum, "piece-num", "n", 1, "The number of pieces to generate")
+	addGenPieceFlags(SimpleSectorBuilderGenPieceCmd)
+	addImportFlags(SimpleSectorBuilderAddPieceCmd)
+	SimpleSectorBuilderExportSectorsCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "The bundle file to export into")
+	SimpleSectorBuilderExportSectorsCmd.Flags().UintSliceVar(&exportSectors, "sector", nil, "The sealed sectors to export, defaults to all sealed sectors")
+	SimpleSectorBuilderExportSectorsCmd.Flags().BoolVar(&exportReplicas, "with-replicas", false, "Export sealed replica files too, which are needed for PoSt")
+	_ = SimpleSectorBuilderExportSectorsCmd.MarkFlagRequired("output")
+	SimpleSectorBuilderImportSectorsCmd.Flags().BoolVar(&importForce, "force", false, "Overwrite sealed sectors which already exist")
+	addPoStFlags(SimpleSectorBuilderVerifySectorsPostCmd)
+	addCorruptFlags(SimpleSectorBuilderCorruptSectorCmd)
+	SimpleSectorBuilderCheckSectorsCmd.Flags().BoolVar(&checkSectorsSample, "sample", false, "Sample Merkle challenges of each sector against its CommR by a single sector PoSt")
+	SimpleSectorBuilderUnsealCmd.Flags().StringVar(&simpleUnsealPiece, "piece", "", "The cid of the piece to unseal, required if the sector holds more than one piece")
+	SimpleSectorBuilderSealSectorsCmd.Flags().IntVar(&sealMaxParallel, "max-parallel", 1, "The max number of sectors sealed in parallel")
+	SimpleSectorBuilderSealSectorsCmd.Flags().StringVar(&sealOrder, "order", backend.SealOrderOldest, "The order to seal sectors in, oldest or fullest first")
+	SimpleSectorBuilderSealSectorsCmd.Flags().Uint64Var(&sealMemoryPerSector, "mem-per-sector", 0, "The available memory in bytes required to start sealing one more sector, 0 for 8 times the sector size")
+	SimpleSectorBuilderSealSectorsCmd.Flags().UintSliceVar(&sealSectors, "sector", nil, "The staged sectors to seal, defaults to all staged sectors")
+	SimpleSectorBuilderSealSectorsCmd.Flags().BoolVar(&sealResume, "resume", false, "Seal again the sectors left in sealing state by a dead process")
+	SimpleSectorBuilderSealSectorsCmd.Flags().BoolVar(&sealRetryFailed, "retry-failed", false, "Seal again the sectors which failed sealing")
+	SimpleSectorBuilderSealSectorsCmd.Flags().Float64Var(&sealMinFill, "min-fill", 0, "Only seal staged sectors filled at least this percent")
+	SimpleSectorBuilderUnsealCmd.Flags().StringVarP(&simpleUnsealOutput, "output", "o", "", "The file to save the unsealed piece into, defaults to the piece cid")
 }
 
 var SimpleSectorBuilderCmd = &cobra.Command{
-	Use:   "simple-sector-builder",
+	Use:   simple.Name,
 	Short: "Commands for filecoin simple sector builder",
 }
 
@@ -62,6 +79,7 @@ var SimpleSectorBuilderLsPiecesCmd = &cobra.Command{
 var SimpleSectorBuilderGetPiecesCmd = &cobra.Command{
 	Use:   "get-piece <cid> <file>",
 	Short: "Get piece and save into file",
+	Long:  "Get piece and save into file, or reconstruct a directory piece as the directory.",
 	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		SectorBuilderGetPiecesCmd.Run(cmd, args)
@@ -75,427 +93,275 @@ var SimpleSectorBuilderGetPiecesCmd = &cobra.Command{
 	},
 }
 
+var SimpleSectorBuilderCommPCmd = &cobra.Command{
+	Use:   "comm-p <file|cid>",
+	Short: "Compute piece commitment (CommP) of a file or a piece",
+	Long:  SectorBuilderCommPCmd.Long,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		SectorBuilderCommPCmd.Run(cmd, args)
+	},
+}
+
 var SimpleSectorBuilderAddPieceCmd = &cobra.Command{
-	Use:   "add-piece <file>",
+	Use:   "add-piece <file|dir>",
 	Short: "Add piece",
+	Long:  "Add a file as a piece. With --recursive, a directory tree is added as one UnixFS directory piece whose piece data is a tar archive of the tree, or as one piece per file with --per-file.",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		filename := args[0]
-		file, err := os.Open(filename)
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
-
-		dag := openSectorBuilderPiecesDAG()
-		defer dag.Close()
-		ds := openMetaDatastore()
-
-		nd, err := dag.dag.ImportData(context.Background(), file)
-		if err != nil {
-			panic(err)
-		}
-		err = ds.Put(makeKey(metaSectorBuilderPiecePrefix, nd.Cid().String()), nil)
-		if err != nil {
+		if submitDaemonJob(&Job{Kind: JobAddPiece, Builder: SimpleSectorBuilderCmd.Use, File: args[0], Recursive: pieceRecursive, PerFile: piecePerFile, Import: &pieceImportOptions, NoSeal: true}) {
 			return
 		}
-
-		ds.Close()
-
-		sb := openSimpleSectorBuilder()
-		defer sb.Close()
-
-		r, err := dag.dag.Cat(context.Background(), nd.Cid())
-		if err != nil {
-			return
-		}
-		t := time.Now()
-		sectorID, err := sb.AddPiece(context.Background(), minerAddr, nd.Cid(), r.Size(), r)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("Added piece %s into staging sector %d, took %v\n", nd.Cid(), sectorID, time.Since(t))
+		exitOnError(addPieces(SimpleSectorBuilderCmd.Use, args[0], true, 1))
 	},
 }
 
 var SimpleSectorBuilderGenPieceCmd = &cobra.Command{
-	Use:   "generate-piece <file>",
+	Use:   "generate-piece",
 	Short: "Generate piece",
+	Long:  "Generate synthetic pieces of the sizes and content pattern given by the flags, and add them into staged sectors. Sizes and the seeded and text patterns are reproducible by --seed.",
+	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSimpleSectorBuilder()
-		defer sb.Close()
+		exitOnError(generatePieces(cmd, SimpleSectorBuilderCmd.Use, pieceNum, true))
+	},
+}
 
-		for i := 0; i < simplePieceNum; i++ {
-			pieceData := make([]byte, sb.MaxBytesPerSector.Uint64())
-			_, err := io.ReadFull(rand.Reader, pieceData)
-			if err != nil {
-				panic(err)
-			}
+func sealOptions() backend.SealOptions {
+	return backend.SealOptions{
+		MaxParallel:     sealMaxParallel,
+		Order:           sealOrder,
+		MemoryPerSector: sealMemoryPerSector,
+		Sectors:         uintsToUint64s(sealSectors),
+		MinFill:         sealMinFill,
+		Resume:          sealResume,
+		RetryFailed:     sealRetryFailed,
+	}
+}
 
-			data := merkledag.NewRawNode(pieceData)
+var SimpleSectorBuilderSealSectorsCmd = &cobra.Command{
+	Use:   "seal-sectors",
+	Short: "Seal staged sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := sealOptions()
+		if submitDaemonJob(&Job{Kind: JobSeal, Builder: SimpleSectorBuilderCmd.Use, Seal: &opts}) {
+			return
+		}
+		exitOnError(sealStagedSectors(SimpleSectorBuilderCmd.Use, &opts))
+	},
+}
 
-			t := time.Now()
-			sectorID, err := sb.AddPiece(context.Background(), minerAddr, data.Cid(), uint64(len(pieceData)), bytes.NewReader(pieceData))
-			if err != nil {
-				panic(err)
-			}
-			fmt.Printf("Generate and add piece %s with size %d into staging sector %d, took %v\n", data.Cid(), len(pieceData), sectorID, time.Since(t))
+var SimpleSectorBuilderVerifySectorsPorepCmd = &cobra.Command{
+	Use:   "verify-sectors-porep",
+	Short: "Verify PoRep (Proof-of-Replication) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		if submitDaemonJob(&Job{Kind: JobVerify, Builder: SimpleSectorBuilderCmd.Use}) {
+			return
 		}
+		exitOnError(verifySectorsPoRep(SimpleSectorBuilderCmd.Use))
 	},
 }
 
-type SimpleSectorBuilder struct {
-	ptr               unsafe.Pointer
-	sectorManager     *multisectorbuilder.SectorStateManager
-	MetaStore         *Datastore
-	MaxBytesPerSector *types.BytesAmount
+var SimpleSectorBuilderLsSectorsCmd = &cobra.Command{
+	Use:   "ls-sectors",
+	Short: "List all sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(lsSectors(SimpleSectorBuilderCmd.Use))
+	},
 }
 
-func (sb *SimpleSectorBuilder) AddPiece(ctx context.Context, minerAddr address.Address, pieceRef cid.Cid, pieceSize uint64, reader io.Reader) (sectorID uint64, err error) {
-	var staged [] multisectorbuilder.StagedSectorMetadata
-	stagedMap, err := sb.sectorManager.GetStaged(minerAddr)
-	if err == nil {
-		for _, s := range stagedMap {
-			if s.State == sealing_state.Pending {
-				staged = append(staged, s)
+var SimpleSectorBuilderSectorInfoCmd = &cobra.Command{
+	Use:   "sector-info <sector-id>",
+	Short: "Show details of a sector",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
-		}
-	}
+		}()
 
-	sectorID, err = go_sectorbuilder.AddPieceFirst(sb.ptr, minerAddr.String(), staged, pieceSize, sb.sectorManager.GetNextSectorID(minerAddr))
-	if err != nil {
-		fmt.Printf("get sector id for adding piece: %s\n", err)
-		return 0, err
-	}
-	var sector multisectorbuilder.StagedSectorMetadata
-	var found bool
-	for _, s := range staged {
-		if sectorID == s.SectorID {
-			found = true
-			sector = s
-			break
-		}
-	}
-	if !found {
-		sector = multisectorbuilder.StagedSectorMetadata{
-			SectorID: sectorID,
-			State:    sealing_state.Pending,
+		sectorID, err := strconv.ParseUint(args[0], 0, 64)
+		if err != nil {
+			return
 		}
-	}
-
-	file, err := ioutil.TempFile("", "")
-	if err != nil {
-		return 0, err
-	}
 
-	defer func() {
-		err1 := os.Remove(file.Name())
-		if err1 != nil && err == nil {
-			err = err1
+		sb, err := simple.OpenSectorBuilder(builderConfig())
+		if err != nil {
+			return
 		}
-	}()
-
-	n, err := io.Copy(file, reader)
-	if err != nil {
-		return 0, err
-	}
-
-	if uint64(n) != pieceSize {
-		err = fmt.Errorf("was unable to write all piece bytes to temp file (wrote %dB, pieceSize %dB)", n, pieceSize)
-		return 0, err
-	}
-
-	meta, err := go_sectorbuilder.AddPieceSecond(sb.ptr, minerAddr.String(), sector, pieceRef.String(), pieceSize, file.Name())
-	if err != nil {
-		return 0, err
-	}
-
-	meta.UpdatedAt = time.Now()
-
-	err = sb.sectorManager.PutStaged(minerAddr, meta)
-	if err != nil {
-		return 0, err
-	}
-
-	return meta.SectorID, nil
-}
-
-func (sb *SimpleSectorBuilder) Close() {
-	go_sectorbuilder.DestroySimpleSectorBuilder(sb.ptr)
-	sb.MetaStore.Close()
-}
+		defer sb.Close()
 
-func (sb *SimpleSectorBuilder) SealAllStagedUnsealedSectors() {
-	stagedMap, _ := sb.sectorManager.GetStaged(minerAddr) // ignore error
-	var stagedSectorIDs []string
-	if len(stagedMap) > 0 {
-		for id := range stagedMap {
-			stagedSectorIDs = append(stagedSectorIDs, fmt.Sprint(id))
+		info := &SectorInfo{
+			SectorID:          sectorID,
+			MaxBytesPerSector: sb.MaxBytesPerSector.Uint64(),
 		}
-	}
-
-	sealedMap, _ := sb.sectorManager.GetSealed(minerAddr) // ignore error
-	var sealedSectorIDs []string
-	if len(sealedMap) > 0 {
-		for id := range sealedMap {
-			sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(id))
+		info.State, err = meta.GetSectorState(sb.MetaStore, sectorID)
+		if err != nil {
+			return
 		}
-	}
-
-	fmt.Println("Seal all staged sectors ...")
-	fmt.Printf("  staged sectors: [%s]\n", blue(strings.Join(stagedSectorIDs, ", ")))
-	fmt.Printf("  sealed sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
 
-	if len(stagedSectorIDs) == 0 {
-		fmt.Println("No staged sector needs to seal")
-		return
-	}
-
-	var wg sync.WaitGroup
-	for id, stagedSector := range stagedMap {
-		wg.Add(1)
-		go func(id uint64, stagedSector multisectorbuilder.StagedSectorMetadata) {
-			defer wg.Done()
-			start := time.Now()
-			sealedSector, err := go_sectorbuilder.SealStagedSector(sb.ptr, minerAddr.String(), stagedSector, sectorbuilder.AddressToProverID(minerAddr))
-			if err != nil {
-				fmt.Printf("Sealing %s: sector %d, took %v, error %s\n",
-					red("failed"), id, time.Since(start), red(err))
-				return
-			}
-
-			fmt.Printf("Sealing %s: sector %d, took %v\n", blue("succeeded"), id, time.Since(start))
-			for _, pieceInfo := range sealedSector.Pieces {
-				fmt.Printf("  Piece %s, size %d\n", cyan(pieceInfo.Key), pieceInfo.Size)
+		stagedMap, _ := sb.GetStaged() // ignore error
+		if s, ok := stagedMap[sectorID]; ok {
+			info.Staged = true
+			info.StagedPath = sb.StagedSectorPath(s.SectorAccess)
+			info.PiecesKnown = true
+			for _, p := range s.Pieces {
+				info.Pieces = append(info.Pieces, SectorPieceInfo{Ref: p.Key, Size: p.Size})
 			}
-
-			err = sb.sectorManager.PutSealed(minerAddr, sealedSector)
-			if err != nil {
-				panic(err)
+		}
+		sealedMap, _ := sb.GetSealed() // ignore error
+		if s, ok := sealedMap[sectorID]; ok {
+			info.Sealed = true
+			info.SealedPath = sb.SealedSectorPath(s.SectorAccess)
+			info.CommD = s.CommD
+			info.CommR = s.CommR
+			info.CommRStar = s.CommRStar
+			info.Proof = s.Proof
+			info.PiecesKnown = true
+			info.Pieces = nil
+			for _, p := range s.Pieces {
+				info.Pieces = append(info.Pieces, SectorPieceInfo{Ref: p.Key, Size: p.Size})
 			}
-		}(id, stagedSector)
-	}
-	wg.Wait()
-}
-
-func (sb *SimpleSectorBuilder) GeneratePoSt(minerAddr address.Address, r sectorbuilder.GeneratePoStRequest) (sectorbuilder.GeneratePoStResponse, error) {
-	sealedSectorsMap, err := sb.sectorManager.GetSealed(minerAddr)
-	if err != nil {
-		return sectorbuilder.GeneratePoStResponse{}, err
-	}
-	sealedSectors := make([]multisectorbuilder.SealedSectorMetadata, 0, len(r.SortedSectorInfo.Values()))
-	for _, info := range r.SortedSectorInfo.Values() {
-		s, ok := sealedSectorsMap[info.SectorID]
-		if !ok {
-			return sectorbuilder.GeneratePoStResponse{}, errors.Errorf("sealed sector %d not found", info.SectorID)
 		}
-		sealedSectors = append(sealedSectors, s)
-	}
-
-	var faults []uint64 // TODO: wait for implementation
-	challenges, err := go_sectorbuilder.GeneratePoStFirst(sb.ptr, r.ChallengeSeed, faults, sealedSectors)
-	if err != nil {
-		return sectorbuilder.GeneratePoStResponse{}, err
-	}
-
-	challengedSectors := make([]multisectorbuilder.SealedSectorMetadata, 0, len(challenges))
-	for _, c := range challenges {
-		s, ok := sealedSectorsMap[c.Sector]
-		if !ok {
-			return sectorbuilder.GeneratePoStResponse{}, errors.Errorf("sealed sector %d not found", c.Sector)
+		if !info.Staged && !info.Sealed {
+			err = fmt.Errorf("sector %d not found", sectorID)
+			return
 		}
-		challengedSectors = append(challengedSectors, s)
-	}
-
-	proof, err := go_sectorbuilder.GeneratePoStSecond(sb.ptr, minerAddr.String(), challenges, faults, challengedSectors)
-	if err != nil {
-		return sectorbuilder.GeneratePoStResponse{}, err
-	}
-	postRep := &sectorbuilder.GeneratePoStResponse{
-		Proof: proof,
-	}
-	_ = sb.sectorManager.PutPoSt(minerAddr, &r, postRep) // ignore cache error
-	return *postRep, nil
-}
 
-func openSimpleSectorBuilder() *SimpleSectorBuilder {
-	ds := openMetaDatastore()
-
-	stagingDir := filepath.Join(getFilutilDir(), "staging")
-	sealedDir := filepath.Join(getFilutilDir(), "sealed")
-
-	sectorClass := types.NewSectorClass(types.TwoHundredFiftySixMiBSectorSize)
-
-	ptr, err := go_sectorbuilder.InitSimpleSectorBuilder(
-		sectorClass.SectorSize().Uint64(),
-		uint8(sectorClass.PoRepProofPartitions().Int()),
-		uint8(sectorClass.PoStProofPartitions().Int()),
-		sealedDir,
-		stagingDir,
-		sectorbuilder.MaxNumStagedSectors,
-	)
-
-	if err != nil {
-		panic(err)
-	}
-
-	max := types.NewBytesAmount(go_sectorbuilder.GetMaxUserBytesPerStagedSector(sectorClass.SectorSize().Uint64()))
-
-	sb := &SimpleSectorBuilder{
-		ptr:               ptr,
-		sectorManager:     multisectorbuilder.NewSectorStateManager(ds.Datastore),
-		MetaStore:         ds,
-		MaxBytesPerSector: max,
-	}
-
-	err = sb.sectorManager.LoadMiner(minerAddr)
-	if err != nil {
-		panic(err)
-	}
-
-	return sb
+		printSectorInfo(info)
+	},
 }
 
-var SimpleSectorBuilderSealSectorsCmd = &cobra.Command{
-	Use:   "seal-sectors",
-	Short: "Seal all staged sectors",
+var SimpleSectorBuilderCheckSectorsCmd = &cobra.Command{
+	Use:   "check-sectors",
+	Short: "Check sealed sectors for faults",
+	Long:  "Check sealed sectors for faults: the replica file must exist with the sector size and be readable, and with --sample, Merkle challenges sampled from it must match CommR.",
 	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSimpleSectorBuilder()
-		defer sb.Close()
-
-		sb.SealAllStagedUnsealedSectors()
+		exitOnError(checkSectors(SimpleSectorBuilderCmd.Use, checkSectorsSample, SimpleSectorBuilderCmd.Use+" verify-sectors-post"))
 	},
 }
 
-var SimpleSectorBuilderVerifySectorsPorepCmd = &cobra.Command{
-	Use:   "verify-sectors-porep",
-	Short: "Verify PoRep (Proof-of-Replication) of all sealed sectors",
+var SimpleSectorBuilderCorruptSectorCmd = &cobra.Command{
+	Use:   "corrupt-sector <sector-id>",
+	Short: "Deliberately damage the replica of a sealed sector for fault testing",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSimpleSectorBuilder()
-		defer sb.Close()
-
-		sealedMap, _ := sb.sectorManager.GetSealed(minerAddr) // ignore error
-		var sealedSectorIDs []string
-		if len(sealedMap) > 0 {
-			for id := range sealedMap {
-				sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(id))
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
+		}()
+
+		sectorID, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return
 		}
 
-		fmt.Printf("All sealed sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
-		if len(sealedMap) == 0 {
+		ds, err := meta.Open(getFilutilDir())
+		if err != nil {
 			return
 		}
+		defer ds.Close()
+		sectorManager := simple.OpenSectorStateManager(ds, minerAddr)
 
-		for _, s := range sealedMap {
-			fmt.Printf("Verify sector %d: ", s.SectorID)
-			t := time.Now()
-			res, err := (&verification.RustVerifier{}).VerifySeal(verification.VerifySealRequest{
-				CommD:      s.CommD,
-				CommR:      s.CommR,
-				CommRStar:  s.CommRStar,
-				Proof:      s.Proof,
-				ProverID:   sectorbuilder.AddressToProverID(minerAddr),
-				SectorID:   s.SectorID,
-				SectorSize: types.TwoHundredFiftySixMiBSectorSize,
-			})
-			if err != nil {
-				fmt.Printf("error %s", red(err))
-			} else if !res.IsValid {
-				fmt.Print(red("invalid"))
-			} else {
-				fmt.Print("valid")
-			}
-			fmt.Printf(", took %v\n", time.Since(t))
+		sealedMap, _ := sectorManager.GetSealed(minerAddr) // ignore error
+		s, ok := sealedMap[sectorID]
+		if !ok {
+			err = fmt.Errorf("sealed sector %d not found", sectorID)
+			return
+		}
+		replicaPath := corruptReplicaPath
+		if replicaPath == "" {
+			replicaPath = simple.SectorAccessPath(filepath.Join(getFilutilDir(), "sealed"), s.SectorAccess)
 		}
+
+		err = corruptReplica(replicaPath, corruptMode, corruptOffset, corruptTruncateTo)
 	},
 }
 
-var SimpleSectorBuilderLsSectorsCmd = &cobra.Command{
-	Use:   "ls-sectors",
-	Short: "List all sectors",
+var SimpleSectorBuilderExportSectorsCmd = &cobra.Command{
+	Use:   "export-sectors",
+	Short: "Export sealed sector metadata and optionally replica files into a bundle",
 	Run: func(cmd *cobra.Command, args []string) {
-		sb := openSimpleSectorBuilder()
-		defer sb.Close()
-
-		fmt.Println(green("Staged sectors:"))
-		stagedMap, _ := sb.sectorManager.GetStaged(minerAddr)
-		if len(stagedMap) > 0 {
-			for id := range stagedMap {
-				fmt.Printf("  Sector %d\n", id)
-			}
-		}
+		exitOnError(exportBundle(SimpleSectorBuilderCmd.Use, exportOutput, uintsToUint64s(exportSectors), exportReplicas))
+	},
+}
 
-		fmt.Println(green("Sealed sectors:"))
-		sealedMap, _ := sb.sectorManager.GetSealed(minerAddr)
-		if len(sealedMap) > 0 {
-			for id, sector := range sealedMap {
-				fmt.Printf("  Sector %d\n", id)
-				for _, p := range sector.Pieces {
-					fmt.Printf("    Piece %s, size %d\n", cyan(p.Key), p.Size)
-				}
-			}
-		}
+var SimpleSectorBuilderImportSectorsCmd = &cobra.Command{
+	Use:   "import-sectors <bundle>",
+	Short: "Import sealed sector metadata and replica files from a bundle",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(importBundle(SimpleSectorBuilderCmd.Use, args[0], importForce))
 	},
 }
 
-var SimpleSectorBuilderVerifySectorsPostCmd = &cobra.Command{
-	Use:   "verify-sectors-post",
-	Short: "Challenge and verify PoSt (Proof-of-Spacetime) of all sealed sectors",
+var SimpleSectorBuilderUnsealCmd = &cobra.Command{
+	Use:   "unseal <sector-id>",
+	Short: "Unseal piece from sealed sector and compare it with the pieces DAG copy",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var challengeSeed types.PoStChallengeSeed
-		_, err := io.ReadFull(rand.Reader, challengeSeed[:])
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		sectorID, err := strconv.ParseUint(args[0], 0, 64)
 		if err != nil {
-			panic(err)
+			return
 		}
-		fmt.Printf("Use challenge seed: %s\n", hex.EncodeToString(challengeSeed[:]))
 
-		sb := openSimpleSectorBuilder()
+		sb, err := simple.OpenSectorBuilder(builderConfig())
+		if err != nil {
+			return
+		}
 		defer sb.Close()
 
-		sealedMap, _ := sb.sectorManager.GetSealed(minerAddr) // ignore error
-		var sectorInfos []go_sectorbuilder.SectorInfo
-		var sealedSectorIDs []string
-		if len(sealedMap) > 0 {
-			for id, s := range sealedMap {
-				sectorInfos = append(sectorInfos, go_sectorbuilder.SectorInfo{
-					SectorID: s.SectorID,
-					CommR:    s.CommR,
-				})
-				sealedSectorIDs = append(sealedSectorIDs, fmt.Sprint(id))
+		sealedMap, _ := sb.GetSealed() // ignore error
+		sector, ok := sealedMap[sectorID]
+		if !ok {
+			err = fmt.Errorf("sealed sector %d not found", sectorID)
+			return
+		}
+
+		var pieceRefs []cid.Cid
+		for _, p := range sector.Pieces {
+			var c cid.Cid
+			c, err = cid.Parse(p.Key)
+			if err != nil {
+				return
 			}
+			pieceRefs = append(pieceRefs, c)
+		}
+		pieceRef, err := selectSectorPiece(sectorID, pieceRefs, simpleUnsealPiece)
+		if err != nil {
+			return
 		}
-		sortedSectorInfo := go_sectorbuilder.NewSortedSectorInfo(sectorInfos...)
 
-		fmt.Println("Generate PoSt ...")
 		t := time.Now()
-		gres, err := sb.GeneratePoSt(minerAddr, sectorbuilder.GeneratePoStRequest{
-			SortedSectorInfo: sortedSectorInfo,
-			ChallengeSeed:    challengeSeed,
-		})
+		r, err := sb.ReadPieceFromSealedSector(sectorID, pieceRef)
 		if err != nil {
-			panic(err)
+			return
 		}
-		fmt.Printf("  sectors: [%s]\n", blue(strings.Join(sealedSectorIDs, ", ")))
-		fmt.Printf("  proof %s, took %v\n", hex.EncodeToString(gres.Proof), time.Since(t))
-
-		fmt.Println("Verify PoSt ...")
-		t = time.Now()
-		vres, err := (&verification.RustVerifier{}).VerifyPoSt(verification.VerifyPoStRequest{
-			ChallengeSeed:    challengeSeed,
-			SortedSectorInfo: sortedSectorInfo,
-			Faults:           []uint64{},
-			Proof:            gres.Proof,
-			SectorSize:       types.TwoHundredFiftySixMiBSectorSize,
-		})
+		err = saveUnsealedPiece(r, pieceRef, simpleUnsealOutput)
 		if err != nil {
-			panic(err)
-		}
-		if !vres.IsValid {
-			fmt.Print(red("  invalid"))
-		} else {
-			fmt.Print("  valid")
+			return
 		}
-		fmt.Printf(", took %v\n", time.Since(t))
+		fmt.Printf("Unsealed piece %s from sector %d, took %v\n", pieceRef, sectorID, time.Since(t))
+	},
+}
+
+var SimpleSectorBuilderVerifySectorsPostCmd = &cobra.Command{
+	Use:   "verify-sectors-post",
+	Short: "Challenge and verify PoSt (Proof-of-Spacetime) of all sealed sectors",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(verifySectorsPoSt(SimpleSectorBuilderCmd.Use))
 	},
 }
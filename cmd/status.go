@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/spf13/cobra"
+
+	"github.com/filcloud/filutil/backend"
+	sbbackend "github.com/filcloud/filutil/backend/sectorbuilder"
+	"github.com/filcloud/filutil/meta"
+)
+
+// statusDirs are the subdirectories of the filutil directory.
+var statusDirs = []string{"meta", "pieces", "staging", "sealed", "metadata"}
+
+// pendingSectorStates are the states of sectors which still need sealing.
+var pendingSectorStates = []string{meta.SectorStaged, meta.SectorFull, meta.SectorSealing, meta.SectorFailed}
+
+var statusFormat string
+
+func init() {
+	rootCmd.AddCommand(StatusCmd)
+
+	StatusCmd.Flags().StringVar(&statusFormat, "format", BenchFormatTable, "The report format, table, csv or json")
+}
+
+type StatusDir struct {
+	Name string
+	Size uint64
+}
+
+// StatusReport is an overview of a filutil directory.
+type StatusReport struct {
+	Dir  string
+	Dirs []StatusDir
+	// ValueLogSize is the size of the badger value logs of the meta and
+	// pieces datastores.
+	ValueLogSize     uint64
+	Pieces           int
+	PiecesInSectors  int
+	Sectors          map[string]int
+	LastUsedSectorID uint64
+	SectorSize       uint64
+	Miner            string
+	// PendingSectors are the sectors which still need sealing, each of which
+	// needs a sealed replica of the sector size.
+	PendingSectors   int
+	PendingSealBytes uint64
+	FreeDisk         uint64
+	// UntrackedSealed are the sealed sectors of the sector builder without a
+	// sector state, sealed before filutil recorded sector states. They are
+	// counted as sealed.
+	UntrackedSealed int
+}
+
+func newStatusReport(ds *meta.Datastore) (*StatusReport, error) {
+	dir := getFilutilDir()
+	r := &StatusReport{
+		Dir:        dir,
+		Sectors:    map[string]int{},
+		SectorSize: sectorSize.Uint64(),
+		Miner:      minerAddr.String(),
+	}
+	for _, name := range statusDirs {
+		r.Dirs = append(r.Dirs, StatusDir{Name: name, Size: dirSize(filepath.Join(dir, name))})
+	}
+	for _, name := range []string{"meta", "pieces"} {
+		vlogs, err := filepath.Glob(filepath.Join(dir, name, "*.vlog"))
+		if err != nil {
+			return nil, err
+		}
+		for _, vlog := range vlogs {
+			if stat, err := os.Stat(vlog); err == nil {
+				r.ValueLogSize += uint64(stat.Size())
+			}
+		}
+	}
+
+	pieces, err := getPieceRecordList(ds)
+	if err != nil {
+		return nil, err
+	}
+	r.Pieces = len(pieces)
+	for _, p := range pieces {
+		if p.SectorID != nil {
+			r.PiecesInSectors++
+		}
+	}
+
+	states, err := meta.GetSectorStateList(ds)
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[uint64]bool{}
+	for _, s := range states {
+		r.Sectors[s.State]++
+		tracked[s.SectorID] = true
+	}
+	for _, m := range sbbackend.SealedSectors(ds) {
+		if !tracked[m.SectorID] {
+			r.UntrackedSealed++
+		}
+	}
+	r.Sectors[meta.SectorSealed] += r.UntrackedSealed
+	for _, state := range pendingSectorStates {
+		r.PendingSectors += r.Sectors[state]
+	}
+	r.PendingSealBytes = uint64(r.PendingSectors) * r.SectorSize
+
+	v, err := ds.Get(datastore.NewKey(meta.LastUsedSectorIDPrefix))
+	if err == nil {
+		r.LastUsedSectorID, err = strconv.ParseUint(string(v), 0, 64)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != datastore.ErrNotFound {
+		return nil, err
+	}
+
+	r.FreeDisk, err = freeDisk(dir)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// freeDisk returns the disk space available to unprivileged users on the file
+// system of dir.
+func freeDisk(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// rows returns the report as name and value rows, for the table and csv
+// formats.
+func (r *StatusReport) rows() [][]string {
+	rows := [][]string{{"dir", r.Dir}}
+	for _, d := range r.Dirs {
+		rows = append(rows, []string{d.Name + " size", fmt.Sprint(d.Size)})
+	}
+	rows = append(rows,
+		[]string{"value log size", fmt.Sprint(r.ValueLogSize)},
+		[]string{"pieces", fmt.Sprint(r.Pieces)},
+		[]string{"pieces in sectors", fmt.Sprint(r.PiecesInSectors)},
+	)
+	for _, state := range meta.SectorStates {
+		rows = append(rows, []string{"sectors " + state, fmt.Sprint(r.Sectors[state])})
+	}
+	rows = append(rows,
+		[]string{"untracked sealed sectors", fmt.Sprint(r.UntrackedSealed)},
+		[]string{"last used sector id", fmt.Sprint(r.LastUsedSectorID)},
+		[]string{"sector size", fmt.Sprint(r.SectorSize)},
+		[]string{"miner", r.Miner},
+		[]string{"pending sectors", fmt.Sprint(r.PendingSectors)},
+		[]string{"pending seal bytes", fmt.Sprint(r.PendingSealBytes)},
+		[]string{"free disk", fmt.Sprint(r.FreeDisk)},
+	)
+	return rows
+}
+
+func printStatusTable(r *StatusReport) {
+	fmt.Printf("Filutil directory: %s\n", r.Dir)
+	fmt.Println("Disk usage:")
+	var total uint64
+	for _, d := range r.Dirs {
+		fmt.Printf("  %-9s %s\n", d.Name+":", backend.FormatBytes(d.Size))
+		total += d.Size
+	}
+	fmt.Printf("  %-9s %s\n", "total:", backend.FormatBytes(total))
+	fmt.Printf("  value logs of meta and pieces: %s\n", backend.FormatBytes(r.ValueLogSize))
+
+	fmt.Printf("Pieces: %d, in sectors: %d, not in sectors: %d\n", r.Pieces, r.PiecesInSectors, r.Pieces-r.PiecesInSectors)
+	var states []string
+	for _, state := range meta.SectorStates {
+		n := r.Sectors[state]
+		s := fmt.Sprintf("%s %d", state, n)
+		if (state == meta.SectorFailed || state == meta.SectorInvalid) && n > 0 {
+			s = red(s)
+		}
+		states = append(states, s)
+	}
+	fmt.Printf("Sectors: %s\n", strings.Join(states, ", "))
+	if r.UntrackedSealed > 0 {
+		fmt.Printf("  %d sealed sectors have no recorded state, they were sealed before filutil tracked sector states\n", r.UntrackedSealed)
+	}
+	fmt.Printf("Last used sector ID: %s\n", blue(r.LastUsedSectorID))
+	fmt.Printf("Sector size: %s, miner: %s\n", backend.FormatBytes(r.SectorSize), blue(r.Miner))
+
+	free := backend.FormatBytes(r.FreeDisk)
+	if r.PendingSealBytes > r.FreeDisk {
+		free = red(free)
+	} else {
+		free = green(free)
+	}
+	fmt.Printf("Free disk: %s, pending sealing of %d sectors needs %s\n", free, r.PendingSectors, backend.FormatBytes(r.PendingSealBytes))
+	if r.PendingSealBytes > r.FreeDisk {
+		fmt.Printf("%s not enough free disk to seal the pending sectors\n", yellow("Warning:"))
+	}
+}
+
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the disk usage, pieces and sectors of the filutil directory",
+	Long:  "Show the disk usage of the filutil directory, piece and sector counts, the last used sector ID, the configured sector size and miner, and whether the free disk fits the sealed replicas of the sectors pending sealing.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+
+		switch statusFormat {
+		case BenchFormatTable, BenchFormatCSV, BenchFormatJSON:
+		default:
+			err = fmt.Errorf("invalid format %q, expect table, csv or json", statusFormat)
+			return
+		}
+		if _, err = os.Stat(filepath.Join(getFilutilDir(), "meta")); os.IsNotExist(err) {
+			err = fmt.Errorf("filutil directory %s is not initialized, run filutil init", getFilutilDir())
+			return
+		}
+
+		ds, err := meta.OpenReadOnly(getFilutilDir())
+		if err != nil {
+			return
+		}
+		defer ds.Close()
+
+		r, err := newStatusReport(ds)
+		if err != nil {
+			return
+		}
+		switch statusFormat {
+		case BenchFormatJSON:
+			var b []byte
+			b, err = json.MarshalIndent(r, "", "  ")
+			if err != nil {
+				return
+			}
+			fmt.Println(string(b))
+		case BenchFormatCSV:
+			w := csv.NewWriter(os.Stdout)
+			err = w.WriteAll(append([][]string{{"name", "value"}}, r.rows()...))
+		default:
+			printStatusTable(r)
+		}
+	},
+}
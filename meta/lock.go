@@ -0,0 +1,179 @@
+package meta
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const repoLockFile = "repo.lock"
+
+// repoLockRetryInterval is how often a blocked --wait checks the lock again.
+const repoLockRetryInterval = 500 * time.Millisecond
+
+// LockWait makes LockRepo wait for a filutil directory locked by another
+// process instead of failing.
+var LockWait bool
+
+// LockHolderError returns the error LockRepo fails with rather than wait for
+// the filutil directory dir locked by the process pid, or nil to wait. If it
+// is nil, LockRepo waits for any holder.
+var LockHolderError func(dir string, pid int) error
+
+// RepoLockedError is returned when another process holds the lock of the
+// filutil directory.
+type RepoLockedError struct {
+	Dir string
+	// PID is the process holding the lock, or 0 if it has not written it
+	// yet.
+	PID int
+}
+
+func (e *RepoLockedError) holder() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("process %d", e.PID)
+	}
+	return "another process"
+}
+
+func (e *RepoLockedError) Error() string {
+	return fmt.Sprintf("filutil directory %s is locked by %s, use --wait to wait for it", e.Dir, e.holder())
+}
+
+// RepoLock is a hold on the lock of a filutil directory, which keeps other
+// filutil processes from opening the badger stores and sector builder
+// directories at the same time. The lock is a flock on the lock file, which
+// the kernel releases when the holder exits, so the lock file is never
+// removed and holds the PID of the last holder only for error messages. Holds
+// of one process are counted, since flocks of separately opened files of one
+// process exclude each other.
+type RepoLock struct {
+	dir      string
+	released bool
+}
+
+var repoLocks = struct {
+	sync.Mutex
+	held  map[string]int
+	files map[string]*os.File
+}{held: map[string]int{}, files: map[string]*os.File{}}
+
+// LockRepo locks the filutil directory dir, and returns a RepoLockedError if
+// another process holds it, unless LockWait.
+func LockRepo(dir string) (*RepoLock, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	repoLocks.Lock()
+	defer repoLocks.Unlock()
+	waiting := false
+	for {
+		// Another hold of the process may have taken the lock while this one
+		// slept.
+		if repoLocks.held[dir] > 0 {
+			repoLocks.held[dir]++
+			return &RepoLock{dir: dir}, nil
+		}
+		f, err := tryLockRepo(dir)
+		if err == nil {
+			repoLocks.held[dir] = 1
+			repoLocks.files[dir] = f
+			return &RepoLock{dir: dir}, nil
+		}
+		locked, ok := err.(*RepoLockedError)
+		if ok && locked.PID > 0 && locked.PID != os.Getpid() && LockHolderError != nil {
+			if err := LockHolderError(dir, locked.PID); err != nil {
+				return nil, err
+			}
+		}
+		if !ok || !LockWait {
+			return nil, err
+		}
+		if !waiting {
+			fmt.Printf("Waiting for filutil directory %s locked by %s\n", dir, locked.holder())
+			waiting = true
+		}
+		// Sleep unlocked, so that the holds and releases of other goroutines
+		// go on meanwhile.
+		repoLocks.Unlock()
+		time.Sleep(repoLockRetryInterval)
+		repoLocks.Lock()
+	}
+}
+
+// tryLockRepo flocks the lock file and writes the PID of the process into it,
+// or returns a RepoLockedError with the PID of the holder.
+func tryLockRepo(dir string) (*os.File, error) {
+	path := filepath.Join(dir, repoLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		pid, _ := readRepoLock(path)
+		return nil, &RepoLockedError{Dir: dir, PID: pid}
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func readRepoLock(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid lock file %s", path)
+	}
+	return pid, nil
+}
+
+// Release releases the hold, and unlocks the lock file if it is the last hold
+// of the process.
+func (l *RepoLock) Release() {
+	if l == nil || l.released {
+		return
+	}
+	l.released = true
+
+	repoLocks.Lock()
+	defer repoLocks.Unlock()
+	repoLocks.held[l.dir]--
+	if repoLocks.held[l.dir] > 0 {
+		return
+	}
+	delete(repoLocks.held, l.dir)
+	f := repoLocks.files[l.dir]
+	delete(repoLocks.files, l.dir)
+	// Closing the file releases the flock.
+	err := f.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,89 @@
+package meta
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTryLockRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filutil-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := tryLockRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flocks of separately opened files exclude each other within a process
+	// too, which stands in for another process here.
+	_, err = tryLockRepo(dir)
+	locked, ok := err.(*RepoLockedError)
+	if !ok {
+		t.Fatalf("locking a locked directory returned %v", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Fatalf("lock holder %d, expected %d", locked.PID, os.Getpid())
+	}
+
+	// Closing the lock file unlocks it, as the exit of the holder does.
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err = tryLockRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestLockRepoWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filutil-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "filutil-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+
+	LockWait = true
+	defer func() { LockWait = false }()
+
+	// The separately opened lock file stands in for another process.
+	f, err := tryLockRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *RepoLock)
+	go func() {
+		l, err := LockRepo(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- l
+	}()
+
+	// A waiting LockRepo must not block the locks of other directories.
+	time.Sleep(2 * repoLockRetryInterval)
+	l, err := LockRepo(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l = <-done:
+		l.Release()
+	case <-time.After(10 * repoLockRetryInterval):
+		t.Fatal("LockRepo kept waiting for an unlocked directory")
+	}
+}
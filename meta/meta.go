@@ -0,0 +1,114 @@
+// Package meta is the meta datastore of a filutil directory, which keeps the
+// piece records, sector states and sector builder metadata, and the lock
+// which keeps filutil processes from using one directory at the same time.
+package meta
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/ipfs/go-datastore"
+	badgerds "github.com/ipfs/go-ds-badger"
+	"github.com/pkg/errors"
+
+	"github.com/filcloud/filutil/backend"
+)
+
+const (
+	PiecePrefix                = "/piece"
+	LastUsedSectorIDPrefix     = "/last-used-sector-id"
+	SealedSectorMetadataPrefix = "/sealed-sector-metadata"
+	DaemonJobPrefix            = "/daemon-job"
+	SectorStatePrefix          = "/sector-state"
+	SchemaVersionPrefix        = "/schema-version"
+	SectorSizePrefix           = "/sector-size"
+)
+
+// Datastore is the meta datastore, which holds the lock of the filutil
+// directory while open.
+type Datastore struct {
+	repo.Datastore
+	lock *RepoLock
+}
+
+func (d *Datastore) Close() {
+	defer d.lock.Release()
+	err := d.Datastore.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Open opens the meta datastore of the filutil directory dir, and migrates it
+// to the current schema version.
+func Open(dir string) (*Datastore, error) {
+	d, err := OpenUnmigrated(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = Migrate(d, false)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// OpenUnmigrated opens the meta datastore of the filutil directory dir as it
+// is.
+func OpenUnmigrated(dir string) (*Datastore, error) {
+	lock, err := LockRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	options := &badgerds.DefaultOptions
+	d, err := badgerds.NewDatastore(filepath.Join(dir, "meta"), options)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return &Datastore{Datastore: d, lock: lock}, nil
+}
+
+// OpenReadOnly opens the meta datastore of the filutil directory dir for
+// reading, without taking the repo lock or migrating it, so that it does not
+// wait for or modify a directory in use by another filutil process.
+func OpenReadOnly(dir string) (*Datastore, error) {
+	options := badgerds.DefaultOptions
+	options.ReadOnly = true
+	d, err := badgerds.NewDatastore(filepath.Join(dir, "meta"), &options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the meta datastore read-only, it may be in use by another filutil process")
+	}
+	return &Datastore{Datastore: d}, nil
+}
+
+func MakeKey(parts ...string) datastore.Key {
+	return datastore.KeyWithNamespaces(parts)
+}
+
+func PutSectorSize(ds *Datastore, size uint64) error {
+	return ds.Put(datastore.NewKey(SectorSizePrefix), []byte(fmt.Sprint(size)))
+}
+
+// CheckSectorSize checks size against the sector size the filutil directory
+// was initialized with, since sectors of another size cannot be mixed into
+// it. The size is recorded for directories initialized before it was.
+func CheckSectorSize(ds *Datastore, size uint64) error {
+	v, err := ds.Get(datastore.NewKey(SectorSizePrefix))
+	if err == datastore.ErrNotFound {
+		return PutSectorSize(ds, size)
+	} else if err != nil {
+		return err
+	}
+	recorded, err := strconv.ParseUint(string(v), 0, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid sector size in meta datastore")
+	}
+	if recorded != size {
+		return fmt.Errorf("filutil directory was initialized with sector size %s, but the configured sector size is %s", backend.FormatBytes(recorded), backend.FormatBytes(size))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package meta
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckSectorSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filutil-meta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ds, err := OpenUnmigrated(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	// A directory without a recorded sector size takes the configured one.
+	if err = CheckSectorSize(ds, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSectorSize(ds, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSectorSize(ds, 2048); err == nil {
+		t.Fatal("opening with another sector size succeeded")
+	}
+}
@@ -0,0 +1,271 @@
+package meta
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/pkg/errors"
+)
+
+// Migration upgrades the meta datastore from the schema version before
+// Version to Version. Migrations must be idempotent, since a migration
+// interrupted before the schema version is bumped runs again.
+type Migration struct {
+	Version     uint64
+	Description string
+	// Migrate applies the migration and returns the number of changed keys,
+	// or only counts them if dryRun.
+	Migrate func(ds *Datastore, dryRun bool) (int, error)
+}
+
+// Migrations are the meta datastore migrations in version order. Directories
+// created before schema versions were introduced are version 0.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "convert piece CommP values into piece records",
+		Migrate:     migratePieceRecords,
+	},
+	{
+		Version:     2,
+		Description: "move piece source paths into piece records",
+		Migrate:     migratePieceSourcePaths,
+	},
+	{
+		Version:     3,
+		Description: "move sectors which failed PoRep verification into the invalid state",
+		Migrate:     migrateInvalidSectors,
+	},
+}
+
+// CurrentSchemaVersion is the meta datastore schema version of this filutil.
+var CurrentSchemaVersion = Migrations[len(Migrations)-1].Version
+
+// GetSchemaVersion returns the schema version of the meta datastore, which is
+// 0 if it has none.
+func GetSchemaVersion(ds *Datastore) (uint64, error) {
+	v, err := ds.Get(MakeKey(SchemaVersionPrefix))
+	if err == datastore.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	version, err := strconv.ParseUint(string(v), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid meta datastore schema version %q", v)
+	}
+	return version, nil
+}
+
+func PutSchemaVersion(ds *Datastore, version uint64) error {
+	return ds.Put(MakeKey(SchemaVersionPrefix), []byte(strconv.FormatUint(version, 10)))
+}
+
+// PendingMigrations returns the migrations to bring the meta datastore to the
+// current schema version. It refuses a datastore of a newer filutil, whose
+// layout this filutil may corrupt.
+func PendingMigrations(ds *Datastore) (version uint64, pending []Migration, err error) {
+	version, err = GetSchemaVersion(ds)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > CurrentSchemaVersion {
+		return 0, nil, fmt.Errorf("meta datastore schema version %d is newer than version %d of this filutil, upgrade filutil", version, CurrentSchemaVersion)
+	}
+	for _, m := range Migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return version, pending, nil
+}
+
+// Migrate runs the pending migrations, bumping the schema version after each
+// one.
+func Migrate(ds *Datastore, dryRun bool) error {
+	version, pending, err := PendingMigrations(ds)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		n, err := m.Migrate(ds, dryRun)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate meta datastore to version %d", m.Version)
+		}
+		if dryRun {
+			fmt.Printf("Would migrate to version %d: %s, %d keys\n", m.Version, m.Description, n)
+			continue
+		}
+		err = PutSchemaVersion(ds, m.Version)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			fmt.Printf("Migrated meta datastore from version %d to %d: %s, %d keys\n", version, m.Version, m.Description, n)
+		}
+		version = m.Version
+	}
+	return nil
+}
+
+// decodePieceRecordFields decodes the piece record of piece c into its JSON
+// fields, so that migrations keep the fields they do not know. Pieces
+// imported before records were introduced store only the CommP bytes, or
+// nothing.
+func decodePieceRecordFields(c string, v []byte) (map[string]json.RawMessage, error) {
+	if len(v) > 0 && v[0] == '{' {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(v, &fields); err == nil {
+			return fields, nil
+		}
+	}
+	var commP string
+	switch len(v) {
+	case 0:
+	case 32:
+		commP = hex.EncodeToString(v)
+	default:
+		return nil, fmt.Errorf("invalid record of piece %s", c)
+	}
+	fields := map[string]json.RawMessage{}
+	err := setPieceRecordField(fields, "Cid", c)
+	if err == nil && commP != "" {
+		err = setPieceRecordField(fields, "CommP", commP)
+	}
+	return fields, err
+}
+
+func setPieceRecordField(fields map[string]json.RawMessage, name, value string) error {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	fields[name] = v
+	return nil
+}
+
+func putPieceRecordFields(ds *Datastore, c string, fields map[string]json.RawMessage) error {
+	v, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return ds.Put(MakeKey(PiecePrefix, c), v)
+}
+
+func migratePieceRecords(ds *Datastore, dryRun bool) (int, error) {
+	result, err := ds.Query(query.Query{
+		Prefix: PiecePrefix,
+	})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, entry := range entries {
+		if len(entry.Value) > 0 && entry.Value[0] == '{' && json.Valid(entry.Value) {
+			continue
+		}
+		c := strings.TrimPrefix(entry.Key, PiecePrefix+"/")
+		fields, err := decodePieceRecordFields(c, entry.Value)
+		if err != nil {
+			return n, err
+		}
+		n++
+		if dryRun {
+			continue
+		}
+		err = putPieceRecordFields(ds, c, fields)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// pieceSourcePathPrefix held the paths pieces were imported from, before they
+// were recorded in piece records.
+const pieceSourcePathPrefix = "/source-path"
+
+func migratePieceSourcePaths(ds *Datastore, dryRun bool) (int, error) {
+	result, err := ds.Query(query.Query{
+		Prefix: pieceSourcePathPrefix,
+	})
+	if err != nil {
+		return 0, err
+	}
+	entries, err := result.Rest()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, entry := range entries {
+		n++
+		if dryRun {
+			continue
+		}
+		c := strings.TrimPrefix(entry.Key, pieceSourcePathPrefix+"/")
+		v, err := ds.Get(MakeKey(PiecePrefix, c))
+		if err == nil {
+			var fields map[string]json.RawMessage
+			fields, err = decodePieceRecordFields(c, v)
+			if err != nil {
+				return n, err
+			}
+			var filename string
+			if v, ok := fields["Filename"]; ok {
+				err = json.Unmarshal(v, &filename)
+			}
+			if err == nil && filename == "" {
+				err = setPieceRecordField(fields, "Filename", string(entry.Value))
+				if err == nil {
+					err = putPieceRecordFields(ds, c, fields)
+				}
+			}
+		} else if err == datastore.ErrNotFound {
+			err = nil // the piece is gone, drop its path
+		}
+		if err != nil {
+			return n, err
+		}
+		err = ds.Delete(datastore.NewKey(entry.Key))
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// InvalidPoRepError is the error recorded for sectors which failed PoRep
+// verification, which were in the failed state before schema version 3.
+const InvalidPoRepError = "invalid PoRep"
+
+func migrateInvalidSectors(ds *Datastore, dryRun bool) (int, error) {
+	states, err := GetSectorStateList(ds)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, s := range states {
+		if s.State != SectorFailed || s.Error != InvalidPoRepError {
+			continue
+		}
+		n++
+		if dryRun {
+			continue
+		}
+		s.State = SectorInvalid
+		err = PutSectorState(ds, s)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
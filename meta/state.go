@@ -0,0 +1,210 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/pkg/errors"
+)
+
+const (
+	SectorStaged   = "staged"
+	SectorFull     = "full"
+	SectorSealing  = "sealing"
+	SectorSealed   = "sealed"
+	SectorFailed   = "failed"
+	SectorVerified = "verified"
+	// SectorInvalid is a sealed sector whose PoRep failed verification. It
+	// is not sealed again by --retry-failed, which is for sealing failures.
+	SectorInvalid = "invalid"
+)
+
+var yellow = color.New(color.FgYellow).SprintFunc()
+
+// SectorStates are all sector states in lifecycle order.
+var SectorStates = []string{SectorStaged, SectorFull, SectorSealing, SectorSealed, SectorVerified, SectorInvalid, SectorFailed}
+
+// SectorState tracks a sector through its lifecycle, so that filutil knows
+// where a sector was left if the process died.
+type SectorState struct {
+	SectorID  uint64
+	State     string
+	Error     string `json:",omitempty"`
+	UpdatedAt time.Time
+	// EnteredAt records when the sector last entered each state.
+	EnteredAt map[string]time.Time
+	// LastVerification is the outcome of the last PoRep verification.
+	LastVerification *SectorVerification `json:",omitempty"`
+}
+
+type SectorVerification struct {
+	At    time.Time
+	Valid bool
+	Error string `json:",omitempty"`
+}
+
+func GetSectorState(ds *Datastore, sectorID uint64) (*SectorState, error) {
+	v, err := ds.Get(MakeKey(SectorStatePrefix, fmt.Sprint(sectorID)))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var s SectorState
+	err = json.Unmarshal(v, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetSectorStateList(ds *Datastore) ([]*SectorState, error) {
+	result, err := ds.Query(query.Query{
+		Prefix: SectorStatePrefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var states []*SectorState
+	for entry := range result.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		var s SectorState
+		err = json.Unmarshal(entry.Value, &s)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, &s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].SectorID < states[j].SectorID
+	})
+	return states, nil
+}
+
+func PutSectorState(ds *Datastore, s *SectorState) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ds.Put(MakeKey(SectorStatePrefix, fmt.Sprint(s.SectorID)), b)
+}
+
+// SetSectorState moves the sector into state. The error text is recorded for
+// the failed and invalid states.
+func SetSectorState(ds *Datastore, sectorID uint64, state string, stateErr error) error {
+	s, err := GetSectorState(ds, sectorID)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		s = &SectorState{SectorID: sectorID}
+	}
+	now := time.Now()
+	s.State = state
+	s.Error = ""
+	if stateErr != nil {
+		s.Error = stateErr.Error()
+	}
+	s.UpdatedAt = now
+	if s.EnteredAt == nil {
+		s.EnteredAt = map[string]time.Time{}
+	}
+	s.EnteredAt[state] = now
+
+	err = PutSectorState(ds, s)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save state %s of sector %d", state, sectorID)
+	}
+	return nil
+}
+
+// RecordSectorVerification records the PoRep verification outcome of a sector,
+// and moves it into the verified or invalid state unless verification itself
+// errored.
+func RecordSectorVerification(ds *Datastore, sectorID uint64, valid bool, verifyErr error) error {
+	if verifyErr == nil {
+		var err error
+		if valid {
+			err = SetSectorState(ds, sectorID, SectorVerified, nil)
+		} else {
+			err = SetSectorState(ds, sectorID, SectorInvalid, errors.New(InvalidPoRepError))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := GetSectorState(ds, sectorID)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		s = &SectorState{SectorID: sectorID}
+	}
+	s.LastVerification = &SectorVerification{
+		At:    time.Now(),
+		Valid: valid,
+	}
+	if verifyErr != nil {
+		s.LastVerification.Error = verifyErr.Error()
+	}
+	err = PutSectorState(ds, s)
+	if err != nil {
+		return errors.Wrapf(err, "failed to save verification of sector %d", sectorID)
+	}
+	return nil
+}
+
+// SectorsInStates returns the IDs of sectors in any of the states.
+func SectorsInStates(ds *Datastore, states ...string) ([]uint64, error) {
+	all, err := GetSectorStateList(ds)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, s := range all {
+		for _, state := range states {
+			if s.State == state {
+				ids = append(ids, s.SectorID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// ResumeSectors returns the sectors to seal again for --resume and
+// --retry-failed.
+func ResumeSectors(ds *Datastore, resume, retryFailed bool) ([]uint64, error) {
+	var states []string
+	if resume {
+		states = append(states, SectorSealing)
+	}
+	if retryFailed {
+		states = append(states, SectorFailed)
+	}
+	return SectorsInStates(ds, states...)
+}
+
+// WarnStuckSectors warns about sectors left in the sealing state, which means
+// a previous process died while sealing them.
+func WarnStuckSectors(ds *Datastore) {
+	ids, err := SectorsInStates(ds, SectorSealing)
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	var s []string
+	for _, id := range ids {
+		s = append(s, fmt.Sprint(id))
+	}
+	fmt.Printf("%s sectors [%s] were being sealed when filutil stopped, run seal-sectors --resume to seal them again\n",
+		yellow("Warning:"), strings.Join(s, ", "))
+}